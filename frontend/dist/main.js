@@ -7,6 +7,9 @@ const toolbarEl = document.getElementById('annotationToolbar');
 
 const toolEl = document.getElementById('tool');
 const colorEl = document.getElementById('color');
+const haloEl = document.getElementById('halo');
+const autoColorEl = document.getElementById('autoColor');
+const opacityEl = document.getElementById('opacity');
 const undoBtn = document.getElementById('undo');
 const redoBtn = document.getElementById('redo');
 const saveBtn = document.getElementById('save');
@@ -24,6 +27,7 @@ let ops = [];
 let undone = [];
 let drag = null;
 let baseImagePath = '';
+let captureScale = 1; // DisplayInfo.scale of the captured display
 let baseImage = null;
 let imageView = null;
 
@@ -124,21 +128,168 @@ function draw() {
     ctx.rect(selection.x, selection.y, selection.w, selection.h);
     ctx.clip();
   }
-  for (const op of ops) drawOp(ctx, op);
+  // Mirror SortOps: unnumbered steps continue from the previous step.
+  let step = 0;
+  for (const op of ops) {
+    if (op.kind === 'step') step = op.payload.number || step + 1;
+    ctx.save();
+    if (op.transform) applyTransform(ctx, op.transform);
+    const shown = resolveAutoColor(ctx, op, step);
+    if (needsLayer(shown)) drawLayered(ctx, shown, step, view.scale);
+    else drawOp(ctx, shown, step);
+    ctx.restore();
+  }
   if (drag) drawOp(ctx, { kind: drag.kind, payload: drag.payload });
   ctx.restore();
 }
 
-function drawOp(ctx, op) {
+// Mirror the renderer's default palette for color "auto".
+const AUTO_PALETTE = ['#ff3b30', '#ffcc00', '#34c759', '#0a84ff', '#af52de'];
+const autoColors = new Map(); // op id -> resolved color
+
+function relativeLuminance(r, g, b) {
+  const lin = (v) => {
+    const c = v / 255;
+    return c <= 0.04045 ? c / 12.92 : ((c + 0.055) / 1.055) ** 2.4;
+  };
+  return 0.2126 * lin(r) + 0.7152 * lin(g) + 0.0722 * lin(b);
+}
+
+// Mirror resolveAutoColor: draw the op in black on a layer to find its
+// footprint, then score each palette color by its coverage-weighted contrast
+// ratio against the canvas underneath. The choice is made once per op.
+function resolveAutoColor(ctx, op, step) {
+  if (op.payload.color !== 'auto') return op;
+  let color = autoColors.get(op.id);
+  if (!color) {
+    const { width, height } = ctx.canvas;
+    const layer = document.createElement('canvas');
+    layer.width = width;
+    layer.height = height;
+    const lctx = layer.getContext('2d');
+    lctx.setTransform(ctx.getTransform());
+    drawOp(lctx, { ...op, payload: { ...op.payload, color: '#000000' } }, step);
+    const footprint = lctx.getImageData(0, 0, width, height).data;
+    const under = ctx.getImageData(0, 0, width, height).data;
+    const lums = AUTO_PALETTE.map((hex) => relativeLuminance(...[1, 3, 5].map((i) => parseInt(hex.slice(i, i + 2), 16))));
+    const scores = lums.map(() => 0);
+    for (let i = 0; i < footprint.length; i += 4) {
+      const a = footprint[i + 3];
+      if (!a) continue;
+      const bg = relativeLuminance(under[i], under[i + 1], under[i + 2]);
+      lums.forEach((l, j) => {
+        scores[j] += a * (Math.max(l, bg) + 0.05) / (Math.min(l, bg) + 0.05);
+      });
+    }
+    color = AUTO_PALETTE[scores.indexOf(Math.max(...scores))];
+    autoColors.set(op.id, color);
+  }
+  return { ...op, payload: { ...op.payload, color } };
+}
+
+// Mirror core.Transform: scale, skew and rotate around the pivot, then translate.
+function applyTransform(ctx, t) {
+  const rad = Math.PI / 180;
+  const px = t.pivotX || 0;
+  const py = t.pivotY || 0;
+  ctx.translate(px + (t.translateX || 0), py + (t.translateY || 0));
+  ctx.rotate((t.rotate || 0) * rad);
+  ctx.transform(1, Math.tan((t.skewY || 0) * rad), Math.tan((t.skewX || 0) * rad), 1, 0, 0);
+  ctx.scale(t.scaleX ?? 1, t.scaleY ?? 1);
+  ctx.translate(-px, -py);
+}
+
+// Region adjustment kinds and the amount a new one starts with. They share
+// their names with the canvas filter functions used to preview them.
+const ADJUST_AMOUNTS = { grayscale: 1, invert: 1, brightness: 0.5, contrast: 0.5, saturate: 0.3 };
+
+// Kinds that sample the image; they take no transform, shadow or outline.
+function isPixelEffect(kind) {
+  return kind === 'magnify' || kind === 'spotlight' || kind === 'redact' || kind === 'blur' || kind === 'pixelate' || kind in ADJUST_AMOUNTS;
+}
+
+function needsLayer(op) {
+  return Boolean(op.shadow || op.outline || op.opacity !== undefined || op.blend);
+}
+
+// Mirror renderLayered: draw the op on its own layer, then its shadow and
+// outline from the layer's silhouette, then the layer itself, with the op's
+// opacity and blend applied to the whole group.
+function drawLayered(ctx, op, step, scale) {
+  const layer = document.createElement('canvas');
+  layer.width = ctx.canvas.width;
+  layer.height = ctx.canvas.height;
+  const lctx = layer.getContext('2d');
+  lctx.setTransform(ctx.getTransform());
+  drawOp(lctx, op, step);
+
+  const group = document.createElement('canvas');
+  group.width = layer.width;
+  group.height = layer.height;
+  const gctx = group.getContext('2d');
+  if (op.shadow) {
+    const s = op.shadow;
+    gctx.filter = s.blur ? `blur(${s.blur * scale}px)` : 'none';
+    gctx.drawImage(silhouette(layer, s.color || 'rgba(0,0,0,0.5)'), (s.x || 0) * scale, (s.y || 0) * scale);
+    gctx.filter = 'none';
+  }
+  if (op.outline) {
+    const width = (op.outline.width || 2) * scale;
+    const halo = silhouette(layer, op.outline.color || contrastColor(op.payload.color));
+    for (let r = width; r > 0; r -= 1) {
+      for (let i = 0; i < 16; i++) {
+        const a = (i / 16) * Math.PI * 2;
+        gctx.drawImage(halo, r * Math.cos(a), r * Math.sin(a));
+      }
+    }
+  }
+  gctx.drawImage(layer, 0, 0);
+
+  ctx.save();
+  ctx.setTransform(1, 0, 0, 1, 0, 0);
+  ctx.globalAlpha = op.opacity ?? 1;
+  const blend = op.blend || (op.kind === 'highlight' ? 'multiply' : 'normal');
+  ctx.globalCompositeOperation = blend === 'normal' ? 'source-over' : blend;
+  ctx.drawImage(group, 0, 0);
+  ctx.restore();
+}
+
+function silhouette(layer, color) {
+  const out = document.createElement('canvas');
+  out.width = layer.width;
+  out.height = layer.height;
+  const octx = out.getContext('2d');
+  octx.drawImage(layer, 0, 0);
+  octx.globalCompositeOperation = 'source-in';
+  octx.fillStyle = color;
+  octx.fillRect(0, 0, out.width, out.height);
+  return out;
+}
+
+function contrastColor(color) {
+  const m = /^#([0-9a-f]{2})([0-9a-f]{2})([0-9a-f]{2})/i.exec(color || '#ff3b30');
+  if (!m) return '#ffffff';
+  const [r, g, b] = m.slice(1).map((h) => parseInt(h, 16));
+  return 0.299 * r + 0.587 * g + 0.114 * b >= 128 ? '#000000' : '#ffffff';
+}
+
+function drawOp(ctx, op, step) {
   const p = op.payload;
   ctx.strokeStyle = p.color || '#ff3b30';
   ctx.fillStyle = p.color || '#ff3b30';
   ctx.lineWidth = p.strokeWidth || 2;
+  ctx.setLineDash(p.dash || []);
+  ctx.lineCap = p.cap || 'butt';
+  ctx.lineJoin = p.join || 'miter';
 
   if (op.kind === 'rect') {
     ctx.strokeRect(p.x, p.y, p.w, p.h);
     return;
   }
+  if (op.kind === 'ellipse') {
+    drawEllipse(ctx, p);
+    return;
+  }
   if (op.kind === 'line') {
     ctx.beginPath();
     ctx.moveTo(p.x1, p.y1);
@@ -150,43 +301,606 @@ function drawOp(ctx, op) {
     drawArrow(ctx, p);
     return;
   }
+  if (op.kind === 'measure') {
+    drawMeasure(ctx, p);
+    return;
+  }
+  if (op.kind === 'curve') {
+    strokePolyline(ctx, curvePoints(p));
+    return;
+  }
+  if (op.kind === 'pen') {
+    drawPen(ctx, p);
+    return;
+  }
+  if (op.kind === 'polygon') {
+    drawPolygon(ctx, p);
+    return;
+  }
+  if (op.kind === 'highlight') {
+    drawHighlight(ctx, p);
+    return;
+  }
+  if (op.kind === 'step') {
+    drawStep(ctx, p, step);
+    return;
+  }
+  if (op.kind === 'callout') {
+    drawCallout(ctx, p);
+    return;
+  }
+  if (op.kind === 'magnify') {
+    drawMagnify(ctx, p);
+    return;
+  }
+  if (op.kind === 'spotlight') {
+    drawSpotlight(ctx, p);
+    return;
+  }
   if (op.kind === 'text') {
-    ctx.font = `${p.size || 18}px ui-monospace`;
-    ctx.fillText(p.text || 'Text', p.x, p.y);
+    drawText(ctx, p);
+    return;
+  }
+  if (op.kind === 'keys') {
+    drawKeys(ctx, p);
+    return;
+  }
+  if (op.kind === 'cursor') {
+    drawCursor(ctx, p);
+    return;
+  }
+  if (op.kind === 'click') {
+    drawClick(ctx, p);
+    return;
+  }
+  if (op.kind === 'redact') {
+    ctx.fillStyle = p.color || '#000000';
+    ctx.fillRect(p.x, p.y, p.w, p.h);
     return;
   }
   if (op.kind === 'blur' || op.kind === 'pixelate') {
     ctx.strokeStyle = '#f59e0b';
+    if (p.mask) drawMaskOutline(ctx, p);
+    else ctx.strokeRect(p.x, p.y, p.w, p.h);
+    return;
+  }
+  if (op.kind in ADJUST_AMOUNTS) {
+    drawAdjust(ctx, op.kind, p);
+  }
+}
+
+// Trace the effect's box, or its mask when it has one, as the current path.
+function traceRegion(ctx, p) {
+  const m = p.mask;
+  ctx.beginPath();
+  if (!m) {
+    ctx.rect(p.x, p.y, p.w, p.h);
+    return true;
+  }
+  if (m.shape === 'ellipse') {
+    ctx.ellipse(p.x + p.w / 2, p.y + p.h / 2, Math.abs(p.w) / 2, Math.abs(p.h) / 2, 0, 0, Math.PI * 2);
+    return true;
+  }
+  let points = m.points || [];
+  if (!points.length) return false;
+  // Path masks are smoothed like a pen stroke, as EffectMask.polygon does.
+  if (m.shape === 'path') points = catmullRom(simplifyRDP(points, 1.5), 8);
+  ctx.moveTo(points[0].x, points[0].y);
+  for (const pt of points.slice(1)) ctx.lineTo(pt.x, pt.y);
+  ctx.closePath();
+  return true;
+}
+
+function drawMaskOutline(ctx, p) {
+  if (traceRegion(ctx, p)) ctx.stroke();
+}
+
+// Redraw the canvas onto itself through the matching filter, clipped to the
+// region, so the preview shows the adjusted pixels.
+function drawAdjust(ctx, kind, p) {
+  if (!traceRegion(ctx, p)) return;
+  ctx.save();
+  ctx.clip();
+  ctx.setTransform(1, 0, 0, 1, 0, 0);
+  ctx.filter = `${kind}(${p.amount ?? 1})`;
+  ctx.drawImage(ctx.canvas, 0, 0);
+  ctx.restore();
+}
+
+function drawEllipse(ctx, p) {
+  const rx = Math.abs(p.w) / 2;
+  const ry = Math.abs(p.h) / 2;
+  if (!rx || !ry) return;
+  ctx.lineJoin = 'round';
+  ctx.beginPath();
+  ctx.ellipse(p.x + p.w / 2, p.y + p.h / 2, rx, ry, 0, 0, Math.PI * 2);
+  if (p.fill) {
+    ctx.fillStyle = p.fillColor || p.color || '#ff3b30';
+    ctx.fill();
+  }
+  ctx.stroke();
+}
+
+// Mirror the renderer's simplifyRDP: drop points closer than epsilon to the
+// chord between their neighbours, keeping both ends.
+function simplifyRDP(points, epsilon) {
+  if (points.length < 3 || epsilon <= 0) return points;
+  const keep = points.map((_, i) => i === 0 || i === points.length - 1);
+  const stack = [[0, points.length - 1]];
+  while (stack.length) {
+    const [first, last] = stack.pop();
+    let maxDist = 0;
+    let index = -1;
+    for (let i = first + 1; i < last; i++) {
+      const d = distToSegment(points[i], points[first], points[last]);
+      if (d > maxDist) {
+        maxDist = d;
+        index = i;
+      }
+    }
+    if (index >= 0 && maxDist > epsilon) {
+      keep[index] = true;
+      stack.push([first, index], [index, last]);
+    }
+  }
+  return points.filter((_, i) => keep[i]);
+}
+
+function distToSegment(p, a, b) {
+  const dx = b.x - a.x;
+  const dy = b.y - a.y;
+  const l2 = dx * dx + dy * dy;
+  if (!l2) return Math.hypot(p.x - a.x, p.y - a.y);
+  const t = Math.max(0, Math.min(1, ((p.x - a.x) * dx + (p.y - a.y) * dy) / l2));
+  return Math.hypot(p.x - (a.x + t * dx), p.y - (a.y + t * dy));
+}
+
+// Mirror the renderer's catmullRom: a uniform spline through every point,
+// sampled steps times per segment.
+function catmullRom(points, steps) {
+  if (points.length < 3 || steps < 2) return points;
+  const out = [];
+  for (let i = 0; i < points.length - 1; i++) {
+    const p0 = points[Math.max(i - 1, 0)];
+    const p1 = points[i];
+    const p2 = points[i + 1];
+    const p3 = points[Math.min(i + 2, points.length - 1)];
+    for (let s = 0; s < steps; s++) {
+      const t = s / steps;
+      const t2 = t * t;
+      const t3 = t2 * t;
+      const at = (k) => 0.5 * (2 * p1[k] + (-p0[k] + p2[k]) * t + (2 * p0[k] - 5 * p1[k] + 4 * p2[k] - p3[k]) * t2 + (-p0[k] + 3 * p1[k] - 3 * p2[k] + p3[k]) * t3);
+      out.push({ x: at('x'), y: at('y') });
+    }
+  }
+  out.push(points[points.length - 1]);
+  return out;
+}
+
+function drawPen(ctx, p) {
+  let points = p.points || [];
+  if (!points.length) return;
+  if (p.smooth) points = catmullRom(simplifyRDP(points, p.tolerance || 1.5), 8);
+  ctx.save();
+  ctx.lineCap = 'round';
+  ctx.lineJoin = 'round';
+  ctx.beginPath();
+  ctx.moveTo(points[0].x, points[0].y);
+  for (const pt of points.slice(1)) ctx.lineTo(pt.x, pt.y);
+  if (points.length === 1) ctx.lineTo(points[0].x, points[0].y);
+  ctx.stroke();
+  ctx.restore();
+}
+
+function drawPolygon(ctx, p) {
+  const points = p.points || [];
+  if (points.length < 2) return;
+  ctx.beginPath();
+  ctx.moveTo(points[0].x, points[0].y);
+  for (const pt of points.slice(1)) ctx.lineTo(pt.x, pt.y);
+  if (!p.open) ctx.closePath();
+  if (p.fill) {
+    ctx.fillStyle = p.fillColor || p.color || '#ff3b30';
+    ctx.fill(p.fillRule === 'evenodd' ? 'evenodd' : 'nonzero');
+  }
+  ctx.stroke();
+}
+
+function drawHighlight(ctx, p) {
+  ctx.save();
+  ctx.globalCompositeOperation = 'multiply';
+  ctx.fillStyle = p.color || '#ffeb3b';
+  ctx.strokeStyle = p.color || '#ffeb3b';
+  if (p.points?.length) {
+    ctx.lineWidth = p.strokeWidth || 18;
+    ctx.lineJoin = 'round';
+    ctx.beginPath();
+    ctx.moveTo(p.points[0].x, p.points[0].y);
+    for (const pt of p.points.slice(1)) ctx.lineTo(pt.x, pt.y);
+    ctx.stroke();
+  } else {
+    ctx.fillRect(p.x, p.y, p.w, p.h);
+  }
+  ctx.restore();
+}
+
+function drawStep(ctx, p, step) {
+  const size = p.size || 28;
+  const label = String(p.number || step || 1);
+  ctx.save();
+  ctx.beginPath();
+  ctx.arc(p.x, p.y, size / 2, 0, Math.PI * 2);
+  ctx.fill();
+  ctx.fillStyle = p.textColor || '#ffffff';
+  ctx.font = `bold ${Math.max(1, Math.floor(size * 11 / 20) - Math.floor((label.length - 1) * size / 10))}px "Go Mono", ui-monospace, monospace`;
+  ctx.textAlign = 'center';
+  ctx.textBaseline = 'middle';
+  ctx.fillText(label, p.x, p.y);
+  ctx.restore();
+}
+
+// Outlines of the embedded cursor bitmaps at 1x, relative to the hotspot.
+const CURSOR_SHAPES = {
+  arrow: [[[0, 0], [0, 17], [4.5, 13.2], [7.3, 19.6], [10, 18.4], [7.3, 12.2], [12.2, 12.2]]],
+  ibeam: [
+    [[-3, -7.5], [3, -7.5], [3, -6.5], [-3, -6.5]],
+    [[-0.5, -6.5], [0.5, -6.5], [0.5, 6.5], [-0.5, 6.5]],
+    [[-3, 6.5], [3, 6.5], [3, 7.5], [-3, 7.5]]
+  ]
+};
+
+function drawCursor(ctx, p) {
+  const k = captureScale * (p.scale || 1);
+  ctx.save();
+  ctx.translate(p.x, p.y);
+  ctx.scale(k, k);
+  ctx.beginPath();
+  for (const poly of CURSOR_SHAPES[p.shape || 'arrow']) {
+    ctx.moveTo(...poly[0]);
+    for (const pt of poly.slice(1)) ctx.lineTo(...pt);
+    ctx.closePath();
+  }
+  ctx.setLineDash([]);
+  ctx.lineJoin = 'round';
+  ctx.lineWidth = 2;
+  ctx.strokeStyle = '#ffffff';
+  ctx.stroke();
+  ctx.fillStyle = '#000000';
+  ctx.fill();
+  ctx.restore();
+}
+
+// Mirror renderClick: a dot inside two rings that fade outwards.
+function drawClick(ctx, p) {
+  const r = p.radius || 16;
+  const width = Math.max(2, Math.round(r / 8));
+  ctx.save();
+  ctx.setLineDash([]);
+  ctx.lineWidth = width;
+  for (const [radius, alpha] of [[r - width / 2, 0.35], [r * 0.62, 0.7]]) {
+    ctx.globalAlpha = alpha;
+    ctx.beginPath();
+    ctx.arc(p.x, p.y, radius, 0, Math.PI * 2);
+    ctx.stroke();
+  }
+  ctx.globalAlpha = 0.9;
+  ctx.beginPath();
+  ctx.arc(p.x, p.y, r * 0.3, 0, Math.PI * 2);
+  ctx.fill();
+  ctx.restore();
+}
+
+const KEY_THEMES = {
+  light: { face: '#f8fafc', edge: '#94a3b8', text: '#0f172a', sep: '#475569' },
+  dark: { face: '#334155', edge: '#0f172a', text: '#f1f5f9', sep: '#cbd5e1' }
+};
+
+// Mirror splitKeys: "++" is the plus key itself.
+function splitKeys(s) {
+  const parts = String(s || '').split('+');
+  const keys = [];
+  for (let i = 0; i < parts.length; i++) {
+    let k = parts[i].trim();
+    if (!k && i + 1 < parts.length && !parts[i + 1].trim()) {
+      k = '+';
+      i++;
+    }
+    if (k) keys.push(k);
+  }
+  return keys;
+}
+
+function drawKeys(ctx, p) {
+  const theme = KEY_THEMES[p.theme || 'light'];
+  const size = p.size || 14;
+  const padX = Math.round(size * 0.5);
+  const padY = Math.round(size * 0.3);
+  const depth = Math.max(2, Math.round(size / 7));
+  const gap = Math.round(size * 0.3);
+  const radius = Math.max(3, Math.round(size / 3));
+  const h = Math.ceil(size * 1.1) + 2 * padY;
+  let x = p.x;
+  ctx.save();
+  ctx.textAlign = 'center';
+  ctx.textBaseline = 'middle';
+  splitKeys(p.keys).forEach((key, i) => {
+    if (i > 0) {
+      ctx.font = `bold ${size}px "Go Mono", ui-monospace, monospace`;
+      const sw = ctx.measureText('+').width;
+      ctx.fillStyle = theme.sep;
+      ctx.fillText('+', x + gap + sw / 2, p.y + h / 2);
+      x += sw + 2 * gap;
+    }
+    ctx.font = `${size}px "Go Mono", ui-monospace, monospace`;
+    const w = Math.max(ctx.measureText(key).width + 2 * padX, h);
+    ctx.fillStyle = theme.edge;
+    ctx.beginPath();
+    ctx.roundRect(x, p.y, w, h + depth, radius);
+    ctx.fill();
+    ctx.fillStyle = theme.face;
+    ctx.beginPath();
+    ctx.roundRect(x + 1, p.y + 1, w - 2, h - 1, radius - 1);
+    ctx.fill();
+    ctx.fillStyle = theme.text;
+    ctx.fillText(key, x + w / 2, p.y + h / 2);
+    x += w;
+  });
+  ctx.restore();
+}
+
+// Mirror measureLabel: one decimal place, in points when asked to convert.
+function measureLabel(p) {
+  if (p.label) return p.label;
+  let d = Math.hypot(p.x2 - p.x1, p.y2 - p.y1);
+  if (p.unit === 'pt' && captureScale > 1) d /= captureScale;
+  return `${Math.round(d * 10) / 10} ${p.unit === 'pt' ? 'pt' : 'px'}`;
+}
+
+function drawMeasure(ctx, p) {
+  const len = Math.hypot(p.x2 - p.x1, p.y2 - p.y1);
+  const [dx, dy] = len ? [(p.x2 - p.x1) / len, (p.y2 - p.y1) / len] : [1, 0];
+  const tick = Math.max(6, 3 * ctx.lineWidth);
+  ctx.save();
+  ctx.lineCap = 'butt';
+  ctx.beginPath();
+  ctx.moveTo(p.x1, p.y1);
+  ctx.lineTo(p.x2, p.y2);
+  for (const [x, y] of [[p.x1, p.y1], [p.x2, p.y2]]) {
+    ctx.moveTo(x - dy * tick, y + dx * tick);
+    ctx.lineTo(x + dy * tick, y - dx * tick);
+  }
+  ctx.stroke();
+  const size = p.size || 12;
+  const label = measureLabel(p);
+  ctx.font = `${size}px "Go Mono", ui-monospace, monospace`;
+  const halfW = ctx.measureText(label).width / 2 + size / 2;
+  const halfH = Math.ceil(size * 0.55) + size / 4;
+  const mx = (p.x1 + p.x2) / 2;
+  const my = (p.y1 + p.y2) / 2;
+  ctx.beginPath();
+  ctx.roundRect(mx - halfW, my - halfH, halfW * 2, halfH * 2, halfH);
+  ctx.fill();
+  ctx.fillStyle = p.textColor || '#ffffff';
+  ctx.textAlign = 'center';
+  ctx.textBaseline = 'middle';
+  ctx.fillText(label, mx, my);
+  ctx.restore();
+}
+
+function drawCallout(ctx, p) {
+  const size = p.size || 18;
+  const pad = p.padding || 8;
+  const lines = String(p.text || '').split('\n');
+  ctx.save();
+  ctx.font = `${p.bold ? 'bold ' : ''}${size}px "Go Mono", ui-monospace, monospace`;
+  const lineHeight = Math.ceil(size * 1.2);
+  const ascent = Math.ceil(size * 0.85);
+  const width = Math.max(...lines.map((line) => ctx.measureText(line).width)) + pad * 2;
+  const height = ascent + (lines.length - 1) * lineHeight + Math.ceil(size * 0.25) + pad * 2;
+  const cx = p.x + width / 2;
+  const cy = p.y + height / 2;
+  ctx.beginPath();
+  ctx.roundRect(p.x, p.y, width, height, p.radius || 6);
+  ctx.fill();
+  const a = p.anchor;
+  const outside = a && (Math.abs(a.x - cx) > width / 2 || Math.abs(a.y - cy) > height / 2);
+  if (outside && (!p.tail || p.tail === 'bubble')) {
+    const len = Math.hypot(a.x - cx, a.y - cy);
+    const half = Math.min(12, Math.min(width, height) / 3);
+    const nx = (-(a.y - cy) / len) * half;
+    const ny = ((a.x - cx) / len) * half;
+    ctx.beginPath();
+    ctx.moveTo(cx + nx, cy + ny);
+    ctx.lineTo(a.x, a.y);
+    ctx.lineTo(cx - nx, cy - ny);
+    ctx.fill();
+  } else if (outside && p.tail === 'leader') {
+    const w = p.strokeWidth || 2;
+    ctx.lineWidth = w;
+    ctx.strokeStyle = ctx.fillStyle;
+    ctx.beginPath();
+    ctx.moveTo(cx, cy);
+    ctx.lineTo(a.x, a.y);
+    ctx.stroke();
+    ctx.beginPath();
+    ctx.arc(a.x, a.y, w * 1.5 + 1, 0, Math.PI * 2);
+    ctx.fill();
+    ctx.beginPath();
+    ctx.roundRect(p.x, p.y, width, height, p.radius || 6);
+    ctx.fill();
+  }
+  ctx.fillStyle = p.textColor || '#ffffff';
+  lines.forEach((line, i) => ctx.fillText(line, p.x + pad, p.y + pad + ascent + i * lineHeight));
+  ctx.restore();
+}
+
+function drawMagnify(ctx, p) {
+  if (p.srcW === undefined) {
     ctx.strokeRect(p.x, p.y, p.w, p.h);
+    return;
+  }
+  const zoom = p.zoom || 2;
+  const w = Math.round(p.srcW * zoom);
+  const h = Math.round(p.srcH * zoom);
+  const border = p.strokeWidth || 3;
+  ctx.save();
+  if (p.connector) {
+    ctx.lineWidth = Math.max(1, Math.floor(border / 2));
+    ctx.strokeRect(p.srcX, p.srcY, p.srcW, p.srcH);
+    ctx.beginPath();
+    ctx.moveTo(p.srcX + p.srcW / 2, p.srcY + p.srcH / 2);
+    ctx.lineTo(p.x + w / 2, p.y + h / 2);
+    ctx.stroke();
   }
+  ctx.beginPath();
+  if (p.shape === 'rect') ctx.rect(p.x, p.y, w, h);
+  else ctx.ellipse(p.x + w / 2, p.y + h / 2, w / 2, h / 2, 0, 0, Math.PI * 2);
+  ctx.save();
+  ctx.clip();
+  ctx.imageSmoothingEnabled = p.filter !== 'nearest';
+  ctx.drawImage(baseImage, p.srcX, p.srcY, p.srcW, p.srcH, p.x, p.y, w, h);
+  ctx.restore();
+  ctx.lineWidth = border;
+  ctx.stroke();
+  ctx.restore();
 }
 
-function drawArrow(ctx, p) {
-  const x1 = p.x1;
-  const y1 = p.y1;
-  const x2 = p.x2;
-  const y2 = p.y2;
-  const headSize = p.headSize || 14;
-  const angle = Math.atan2(y2 - y1, x2 - x1);
+function drawSpotlight(ctx, p) {
+  const regions = p.regions || [{ x: p.x, y: p.y, w: p.w, h: p.h }];
+  const iw = baseImage.naturalWidth || baseImage.width;
+  const ih = baseImage.naturalHeight || baseImage.height;
+  ctx.save();
+  ctx.beginPath();
+  ctx.rect(0, 0, iw, ih);
+  for (const r of regions) {
+    if (r.shape === 'ellipse') {
+      ctx.moveTo(r.x + r.w, r.y + r.h / 2);
+      ctx.ellipse(r.x + r.w / 2, r.y + r.h / 2, Math.abs(r.w) / 2, Math.abs(r.h) / 2, 0, 0, Math.PI * 2);
+    } else {
+      ctx.rect(r.x, r.y, r.w, r.h);
+    }
+  }
+  ctx.globalAlpha = p.dim ?? 0.6;
+  ctx.fillStyle = p.color || '#000000';
+  ctx.fill('evenodd');
+  ctx.restore();
+}
+
+function drawText(ctx, p) {
+  const size = p.size || 18;
+  const lines = String(p.text || 'Text').split('\n');
+  ctx.save();
+  ctx.font = `${p.bold ? 'bold ' : ''}${size}px "Go Mono", ui-monospace, monospace`;
+  const lineHeight = Math.ceil(size * 1.2);
+  if (p.background) {
+    const pad = p.padding || 4;
+    const width = Math.max(...lines.map((line) => ctx.measureText(line).width));
+    const ascent = Math.ceil(size * 0.85);
+    const height = ascent + (lines.length - 1) * lineHeight + Math.ceil(size * 0.25);
+    ctx.fillStyle = p.background;
+    ctx.fillRect(p.x - pad, p.y - ascent - pad, width + pad * 2, height + pad * 2);
+  }
+  ctx.fillStyle = p.color || '#ff3b30';
+  lines.forEach((line, i) => ctx.fillText(line, p.x, p.y + i * lineHeight));
+  ctx.restore();
+}
+
+// Mirror CurvePayload.path: a straight segment, or a flattened Bézier.
+function curvePoints(p) {
+  const a = { x: p.x1, y: p.y1 };
+  const b = { x: p.x2, y: p.y2 };
+  if (!p.c1) return [a, b];
+  const c1 = p.c1;
+  const c2 = p.c2;
+  const ctrl = c2 ? dist(a, c1) + dist(c1, c2) + dist(c2, b) : dist(a, c1) + dist(c1, b);
+  const n = Math.min(Math.max(Math.ceil(ctrl / 2), 8), 1024);
+  const pts = [];
+  for (let i = 0; i <= n; i++) {
+    const t = i / n;
+    const u = 1 - t;
+    if (c2) {
+      pts.push({
+        x: u * u * u * a.x + 3 * u * u * t * c1.x + 3 * u * t * t * c2.x + t * t * t * b.x,
+        y: u * u * u * a.y + 3 * u * u * t * c1.y + 3 * u * t * t * c2.y + t * t * t * b.y
+      });
+    } else {
+      pts.push({ x: u * u * a.x + 2 * u * t * c1.x + t * t * b.x, y: u * u * a.y + 2 * u * t * c1.y + t * t * b.y });
+    }
+  }
+  return pts;
+}
+
+function dist(a, b) {
+  return Math.hypot(b.x - a.x, b.y - a.y);
+}
+
+function trimEnd(pts, length) {
+  for (let i = pts.length - 1; i > 0; i--) {
+    const seg = dist(pts[i - 1], pts[i]);
+    if (seg >= length) {
+      const k = seg ? length / seg : 0;
+      const end = { x: pts[i].x + (pts[i - 1].x - pts[i].x) * k, y: pts[i].y + (pts[i - 1].y - pts[i].y) * k };
+      return [...pts.slice(0, i), end];
+    }
+    length -= seg;
+  }
+  return pts.slice(0, 1);
+}
 
+function strokePolyline(ctx, pts) {
   ctx.beginPath();
-  ctx.moveTo(x1, y1);
-  ctx.lineTo(x2, y2);
+  ctx.moveTo(pts[0].x, pts[0].y);
+  for (const pt of pts.slice(1)) ctx.lineTo(pt.x, pt.y);
   ctx.stroke();
+}
 
+// Mirror renderArrow: the head points along the chord over its own length.
+function arrowHead(pts, size) {
+  const tip = pts[pts.length - 1];
+  const back = trimEnd(pts, size).pop();
+  const angle = Math.atan2(tip.y - back.y, tip.x - back.x);
   const a1 = angle + Math.PI * 0.82;
   const a2 = angle - Math.PI * 0.82;
-  const hx1 = x2 + headSize * Math.cos(a1);
-  const hy1 = y2 + headSize * Math.sin(a1);
-  const hx2 = x2 + headSize * Math.cos(a2);
-  const hy2 = y2 + headSize * Math.sin(a2);
+  return [
+    { x: tip.x + size * Math.cos(a1), y: tip.y + size * Math.sin(a1) },
+    tip,
+    { x: tip.x + size * Math.cos(a2), y: tip.y + size * Math.sin(a2) }
+  ];
+}
 
+function fillTriangle(ctx, pts) {
   ctx.beginPath();
-  ctx.moveTo(x2, y2);
-  ctx.lineTo(hx1, hy1);
-  ctx.moveTo(x2, y2);
-  ctx.lineTo(hx2, hy2);
-  ctx.stroke();
+  ctx.moveTo(pts[0].x, pts[0].y);
+  ctx.lineTo(pts[1].x, pts[1].y);
+  ctx.lineTo(pts[2].x, pts[2].y);
+  ctx.closePath();
+  ctx.fill();
+}
+
+function drawArrow(ctx, p) {
+  const headSize = p.headSize || 14;
+  const head = p.head || 'open';
+  const pts = curvePoints(p);
+  const rev = [...pts].reverse();
+  let shaft = pts;
+  if (head === 'triangle') shaft = trimEnd(pts, headSize / 2);
+  if (head === 'double') shaft = trimEnd(trimEnd(pts, headSize / 2).reverse(), headSize / 2).reverse();
+  strokePolyline(ctx, shaft);
+
+  ctx.setLineDash([]);
+  if (head === 'open') {
+    strokePolyline(ctx, arrowHead(pts, headSize));
+  } else if (head === 'triangle') {
+    fillTriangle(ctx, arrowHead(pts, headSize));
+  } else if (head === 'double') {
+    fillTriangle(ctx, arrowHead(pts, headSize));
+    fillTriangle(ctx, arrowHead(rev, headSize));
+  } else if (head === 'dot') {
+    const tip = pts[pts.length - 1];
+    ctx.beginPath();
+    ctx.arc(tip.x, tip.y, Math.max(headSize / 3, p.strokeWidth || 2), 0, Math.PI * 2);
+    ctx.fill();
+  }
 }
 
 function canvasPoint(e) {
@@ -244,14 +958,26 @@ function getImageView() {
   return imageView;
 }
 
+function isBoxTool(kind) {
+  return kind === 'rect' || kind === 'ellipse' || kind === 'highlight' || kind === 'magnify' || kind === 'spotlight' || kind === 'blur' || kind === 'pixelate' || kind === 'redact' || kind in ADJUST_AMOUNTS;
+}
+
 function normalizePayload(kind, p) {
-  if (kind === 'rect' || kind === 'blur' || kind === 'pixelate') {
+  if (isBoxTool(kind)) {
     const x = p.w < 0 ? p.x + p.w : p.x;
     const y = p.h < 0 ? p.y + p.h : p.y;
     const w = Math.abs(p.w);
     const h = Math.abs(p.h);
     if (kind === 'blur') return { x, y, w, h, radius: 3 };
     if (kind === 'pixelate') return { x, y, w, h, size: 12 };
+    if (kind === 'redact') return { x, y, w, h, mode: 'solid', color: '#000000' };
+    if (kind in ADJUST_AMOUNTS) return { x, y, w, h, amount: ADJUST_AMOUNTS[kind] };
+    if (kind === 'highlight') return { x, y, w, h, color: p.color };
+    if (kind === 'spotlight') return { regions: [{ x, y, w, h, shape: 'rect' }], dim: 0.6 };
+    if (kind === 'magnify') {
+      return { srcX: x, srcY: y, srcW: w, srcH: h, x: x + w + 24, y, zoom: 2, color: p.color, connector: true };
+    }
+    if (kind === 'ellipse') return { x, y, w, h, color: p.color, strokeWidth: p.strokeWidth, fill: false };
     return { x, y, w, h, color: p.color, strokeWidth: p.strokeWidth, fill: false };
   }
   return p;
@@ -259,7 +985,14 @@ function normalizePayload(kind, p) {
 
 function pushOp(op) {
   const id = crypto.randomUUID?.() || `${Date.now()}-${Math.random()}`;
-  ops.push({ id, kind: op.kind, z: ops.length, payload: op.payload });
+  const entry = { id, kind: op.kind, z: ops.length, payload: op.payload };
+  if (haloEl.checked && !isPixelEffect(op.kind)) entry.outline = {};
+  if (autoColorEl.checked && !isPixelEffect(op.kind) && 'color' in op.payload) {
+    entry.payload = { ...op.payload, color: 'auto' };
+  }
+  const opacity = Number(opacityEl.value) / 100;
+  if (opacity < 1 && op.kind !== 'redact') entry.opacity = opacity;
+  ops.push(entry);
   undone = [];
   draw();
 }
@@ -323,6 +1056,7 @@ async function beginCapture(mode) {
     debugLog('StartCapture success', result);
 
     baseImagePath = result.imagePath;
+    captureScale = result.displays?.[0]?.scale || 1;
     captureMode = mode;
     ops = [];
     undone = [];
@@ -404,6 +1138,62 @@ canvas.addEventListener('mousedown', (e) => {
     return;
   }
 
+  if (kind === 'keys') {
+    const keys = prompt('Shortcut, e.g. Ctrl+Shift+P');
+    if (!keys || !splitKeys(keys).length) return;
+    // A dark pick selects dark caps, a light one light caps.
+    const theme = contrastColor(colorEl.value) === '#ffffff' ? 'dark' : 'light';
+    pushOp({ kind, payload: { x: pt.x, y: pt.y, keys, theme, size: 14 } });
+    return;
+  }
+
+  if (kind === 'cursor' || kind === 'ibeam') {
+    pushOp({ kind: 'cursor', payload: { x: pt.x, y: pt.y, shape: kind === 'ibeam' ? 'ibeam' : 'arrow' } });
+    return;
+  }
+
+  if (kind === 'click') {
+    pushOp({ kind, payload: { x: pt.x, y: pt.y, color: colorEl.value, radius: 16 } });
+    return;
+  }
+
+  if (kind === 'step') {
+    pushOp({ kind, payload: { x: pt.x, y: pt.y, color: colorEl.value, size: 28 } });
+    return;
+  }
+
+  if (kind === 'callout') {
+    drag = {
+      kind,
+      startX: pt.x,
+      startY: pt.y,
+      payload: { x: pt.x, y: pt.y, text: 'Callout', color: colorEl.value, anchor: pt }
+    };
+    return;
+  }
+
+  // Polygons are built click by click; the last point follows the mouse
+  // and a double-click closes the shape.
+  if (kind === 'polygon') {
+    if (drag?.kind === 'polygon') {
+      drag.payload.points.push(pt);
+    } else {
+      drag = { kind, payload: { points: [pt, pt], color: colorEl.value, strokeWidth: 2 } };
+    }
+    draw();
+    return;
+  }
+
+  if (kind === 'pen') {
+    drag = {
+      kind,
+      startX: pt.x,
+      startY: pt.y,
+      payload: { points: [pt], color: colorEl.value, strokeWidth: 3, smooth: true }
+    };
+    return;
+  }
+
   drag = {
     kind,
     startX: pt.x,
@@ -428,7 +1218,15 @@ canvas.addEventListener('mousemove', (e) => {
 
   if (phase !== 'annotating' || !drag) return;
 
-  if (drag.kind === 'rect' || drag.kind === 'blur' || drag.kind === 'pixelate') {
+  if (drag.kind === 'callout') {
+    drag.payload.x = pt.x;
+    drag.payload.y = pt.y;
+  } else if (drag.kind === 'polygon') {
+    drag.payload.points[drag.payload.points.length - 1] = pt;
+  } else if (drag.kind === 'pen') {
+    const last = drag.payload.points[drag.payload.points.length - 1];
+    if (last.x !== pt.x || last.y !== pt.y) drag.payload.points.push(pt);
+  } else if (isBoxTool(drag.kind)) {
     drag.payload.w = pt.x - drag.startX;
     drag.payload.h = pt.y - drag.startY;
   } else {
@@ -439,6 +1237,14 @@ canvas.addEventListener('mousemove', (e) => {
     drag.payload.color = colorEl.value;
     drag.payload.strokeWidth = 2;
     if (drag.kind === 'arrow') drag.payload.headSize = 14;
+    if (drag.kind === 'measure' && captureScale > 1) drag.payload.unit = 'pt';
+    if (drag.kind === 'curve') {
+      // Bow the curve to the left of the drag by a quarter of its length.
+      drag.payload.c1 = {
+        x: Math.round((drag.startX + pt.x) / 2 + (pt.y - drag.startY) / 4),
+        y: Math.round((drag.startY + pt.y) / 2 - (pt.x - drag.startX) / 4)
+      };
+    }
   }
   draw();
 });
@@ -461,11 +1267,30 @@ canvas.addEventListener('mouseup', () => {
   }
 
   if (phase !== 'annotating' || !drag) return;
+  if (drag.kind === 'polygon') return;
+  if (drag.kind === 'callout') {
+    const text = prompt('Callout text');
+    const payload = drag.payload;
+    drag = null;
+    if (text) pushOp({ kind: 'callout', payload: { ...payload, text } });
+    else draw();
+    return;
+  }
   const payload = normalizePayload(drag.kind, drag.payload);
   pushOp({ kind: drag.kind, payload });
   drag = null;
 });
 
+canvas.addEventListener('dblclick', () => {
+  if (drag?.kind !== 'polygon') return;
+  // Both clicks of the double-click added a vertex; drop the repeats.
+  const points = drag.payload.points.filter((pt, i, all) => i === 0 || pt.x !== all[i - 1].x || pt.y !== all[i - 1].y);
+  const payload = { ...drag.payload, points };
+  drag = null;
+  if (points.length >= 3) pushOp({ kind: 'polygon', payload });
+  else draw();
+});
+
 undoBtn.addEventListener('click', () => {
   if (!ops.length) return;
   undone.push(ops.pop());
@@ -512,7 +1337,8 @@ saveBtn.addEventListener('click', async () => {
       ops,
       format: 'png',
       quality: 90,
-      outputPath: chosenPath
+      outputPath: chosenPath,
+      scale: captureScale
     };
     debugLog('Save request', req);
     const result = await backend().SaveAnnotated(req);
@@ -2,7 +2,10 @@ module github.com/mohamoundaljadan/screenshot
 
 go 1.26.0
 
-require github.com/wailsapp/wails/v2 v2.11.0
+require (
+	github.com/wailsapp/wails/v2 v2.11.0
+	golang.org/x/image v0.25.0
+)
 
 require (
 	github.com/bep/debounce v1.2.1 // indirect
@@ -31,5 +34,5 @@ require (
 	golang.org/x/crypto v0.33.0 // indirect
 	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
-	golang.org/x/text v0.22.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
@@ -0,0 +1,92 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// colorMatrix maps un-premultiplied sRGB channels in 0-1, as the CSS
+// shorthand filters do. The last column is an offset.
+type colorMatrix [3][4]float64
+
+// adjustMatrix returns the CSS filter function named by kind at amount. The
+// coefficients are the ones in the Filter Effects spec so the editor preview,
+// which uses canvas filters, matches the export.
+func adjustMatrix(kind string, amount float64) colorMatrix {
+	switch kind {
+	case "grayscale":
+		s := 1 - amount
+		return colorMatrix{
+			{0.2126 + 0.7874*s, 0.7152 - 0.7152*s, 0.0722 - 0.0722*s, 0},
+			{0.2126 - 0.2126*s, 0.7152 + 0.2848*s, 0.0722 - 0.0722*s, 0},
+			{0.2126 - 0.2126*s, 0.7152 - 0.7152*s, 0.0722 + 0.9278*s, 0},
+		}
+	case "saturate":
+		s := amount
+		return colorMatrix{
+			{0.213 + 0.787*s, 0.715 - 0.715*s, 0.072 - 0.072*s, 0},
+			{0.213 - 0.213*s, 0.715 + 0.285*s, 0.072 - 0.072*s, 0},
+			{0.213 - 0.213*s, 0.715 - 0.715*s, 0.072 + 0.928*s, 0},
+		}
+	case "invert":
+		d := 1 - 2*amount
+		return colorMatrix{{d, 0, 0, amount}, {0, d, 0, amount}, {0, 0, d, amount}}
+	case "brightness":
+		return colorMatrix{{amount, 0, 0, 0}, {0, amount, 0, 0}, {0, 0, amount, 0}}
+	case "contrast":
+		o := 0.5 - 0.5*amount
+		return colorMatrix{{amount, 0, 0, o}, {0, amount, 0, o}, {0, 0, amount, o}}
+	}
+	return colorMatrix{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}}
+}
+
+// lut precomputes the matrix for every 8-bit channel value. Each output
+// channel is the clamped sum of one table entry per input channel.
+func (m colorMatrix) lut() (t [3][3][256]float64) {
+	for out := range 3 {
+		for in := range 3 {
+			for v := range 256 {
+				t[out][in][v] = m[out][in] * float64(v)
+			}
+		}
+	}
+	return t
+}
+
+func applyAdjust(dst draw.Image, kind string, p AdjustPayload) error {
+	amount := 1.0
+	if p.Amount != nil {
+		amount = *p.Amount
+	}
+	box := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H)
+	m := adjustMatrix(kind, amount)
+	adjust := func(img draw.Image, r image.Rectangle) { adjustRect(img, r, m) }
+	if p.Mask != nil {
+		return applyMasked(dst, box, p.Mask, 0, adjust)
+	}
+	adjust(dst, box)
+	return nil
+}
+
+// adjustRect recolors r through m. Pixels are un-premultiplied first so
+// partly transparent canvas areas keep their hue.
+func adjustRect(dst draw.Image, r image.Rectangle, m colorMatrix) {
+	r = r.Intersect(dst.Bounds())
+	t := m.lut()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(dst.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			var ch [3]uint8
+			for i := range ch {
+				v := t[i][0][c.R] + t[i][1][c.G] + t[i][2][c.B] + m[i][3]*255
+				ch[i] = uint8(math.Round(math.Min(math.Max(v, 0), 255)))
+			}
+			dst.Set(x, y, color.NRGBA{R: ch[0], G: ch[1], B: ch[2], A: c.A})
+		}
+	}
+}
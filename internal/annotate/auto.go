@@ -0,0 +1,157 @@
+package annotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+// autoColor in an op's "color" field asks the renderer to pick the palette
+// color that stands out most against the pixels the op covers.
+const autoColor = "auto"
+
+// probeColor stands in for an auto color while validating an op and while
+// finding its footprint.
+const probeColor = "#000000"
+
+// defaultPalette is used when RenderOptions.Palette is empty. Black and white
+// are left out so annotations stay recognisably colored.
+var defaultPalette = []string{"#ff3b30", "#ffcc00", "#34c759", "#0a84ff", "#af52de"}
+
+// ValidatePalette checks the colors auto-colored ops choose from.
+func ValidatePalette(palette []string) error {
+	for _, c := range palette {
+		if c == autoColor {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "palette colors must be concrete"}
+		}
+	}
+	return validateColors(palette...)
+}
+
+func hasAutoColor(op core.AnnotationOp) bool {
+	var p struct {
+		Color string `json:"color"`
+	}
+	return json.Unmarshal(op.Payload, &p) == nil && p.Color == autoColor
+}
+
+// withColor returns op with its payload's "color" field replaced by c.
+func withColor(op core.AnnotationOp, c string) (core.AnnotationOp, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(op.Payload, &fields); err != nil {
+		return op, &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+	}
+	fields["color"], _ = json.Marshal(c)
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return op, &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+	}
+	op.Payload = payload
+	return op, nil
+}
+
+// validateAutoColor rejects auto colors on pixel effects, whose color is not
+// drawn on top of the image, and otherwise returns op with the probe color in
+// place of "auto" so the payload validates like any other.
+func validateAutoColor(op core.AnnotationOp) (core.AnnotationOp, error) {
+	if !hasAutoColor(op) {
+		return op, nil
+	}
+	if _, ok := pixelEffectKinds[op.Kind]; ok {
+		return op, &core.AppError{Code: core.ErrInvalidOpPayload, Message: fmt.Sprintf("auto color is not supported for %s op: %s", op.Kind, op.ID)}
+	}
+	return withColor(op, probeColor)
+}
+
+// resolveAutoColor replaces an auto color with a concrete one. The op is drawn
+// once in the probe color on a transparent layer; that coverage is its
+// footprint, and each palette color is scored by its WCAG contrast ratio
+// against the canvas under the footprint, averaged by coverage.
+func resolveAutoColor(dst draw.Image, op core.AnnotationOp, opts RenderOptions) (core.AnnotationOp, error) {
+	if !hasAutoColor(op) {
+		return op, nil
+	}
+	probe, err := withColor(op, probeColor)
+	if err != nil {
+		return op, err
+	}
+	probe.Shadow, probe.Outline, probe.Opacity, probe.Blend = nil, nil, nil, ""
+	layer := image.NewRGBA(dst.Bounds())
+	if probe.Transform != nil {
+		err = renderTransformed(layer, probe, opts)
+	} else {
+		err = renderOp(layer, probe, opts)
+	}
+	if err != nil {
+		return op, err
+	}
+	palette := opts.Palette
+	if len(palette) == 0 {
+		palette = defaultPalette
+	}
+	best, err := mostContrasting(dst, layer, palette)
+	if err != nil {
+		return op, err
+	}
+	return withColor(op, best)
+}
+
+func mostContrasting(dst image.Image, footprint *image.RGBA, palette []string) (string, error) {
+	lums := make([]float64, len(palette))
+	for i, s := range palette {
+		c, err := parseColor(s)
+		if err != nil {
+			return "", err
+		}
+		lums[i] = relativeLuminance(c)
+	}
+	scores := make([]float64, len(palette))
+	ink := inkBounds(footprint)
+	for y := ink.Min.Y; y < ink.Max.Y; y++ {
+		for x := ink.Min.X; x < ink.Max.X; x++ {
+			w := float64(footprint.Pix[footprint.PixOffset(x, y)+3])
+			if w == 0 {
+				continue
+			}
+			bg := relativeLuminance(color.NRGBAModel.Convert(dst.At(x, y)).(color.NRGBA))
+			for i, l := range lums {
+				scores[i] += w * contrastRatio(l, bg)
+			}
+		}
+	}
+	best := 0
+	for i, s := range scores {
+		if s > scores[best] {
+			best = i
+		}
+	}
+	return palette[best], nil
+}
+
+// srgbToLinear maps 8-bit sRGB channel values to linear light.
+var srgbToLinear = func() (t [256]float64) {
+	for i := range t {
+		s := float64(i) / 255
+		if s <= 0.04045 {
+			t[i] = s / 12.92
+		} else {
+			t[i] = math.Pow((s+0.055)/1.055, 2.4)
+		}
+	}
+	return t
+}()
+
+// relativeLuminance is the WCAG 2 luminance of c's color, ignoring alpha.
+func relativeLuminance(c color.NRGBA) float64 {
+	return 0.2126*srgbToLinear[c.R] + 0.7152*srgbToLinear[c.G] + 0.0722*srgbToLinear[c.B]
+}
+
+// contrastRatio is the WCAG contrast ratio between two luminances, 1 to 21.
+func contrastRatio(a, b float64) float64 {
+	return (math.Max(a, b) + 0.05) / (math.Min(a, b) + 0.05)
+}
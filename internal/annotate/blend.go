@@ -0,0 +1,81 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// blendFunc is a separable blend mode from the W3C compositing spec. It
+// combines a backdrop channel b with a source channel s, both in [0, 1].
+type blendFunc func(b, s float64) float64
+
+func blendNormal(_, s float64) float64   { return s }
+func blendMultiply(b, s float64) float64 { return b * s }
+func blendScreen(b, s float64) float64   { return b + s - b*s }
+
+func blendOverlay(b, s float64) float64 {
+	if b <= 0.5 {
+		return 2 * b * s
+	}
+	return 1 - 2*(1-b)*(1-s)
+}
+
+func blendDifference(b, s float64) float64 { return math.Abs(b - s) }
+
+var knownBlends = map[string]blendFunc{
+	"normal":     blendNormal,
+	"multiply":   blendMultiply,
+	"screen":     blendScreen,
+	"overlay":    blendOverlay,
+	"difference": blendDifference,
+}
+
+// blendMask composites c onto dst through mask using blend instead of plain
+// source-over, following the W3C separable blend formula.
+func blendMask(dst draw.Image, mask *image.Alpha, c color.NRGBA, blend blendFunc) {
+	if mask == nil {
+		return
+	}
+	r := mask.Rect.Intersect(dst.Bounds())
+	src := [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			as := float64(mask.AlphaAt(x, y).A) / 255 * float64(c.A) / 255
+			if as == 0 {
+				continue
+			}
+			dst.Set(x, y, blendPixel(color.NRGBAModel.Convert(dst.At(x, y)).(color.NRGBA), src, as, blend))
+		}
+	}
+}
+
+func blendPixel(bc color.NRGBA, src [3]float64, as float64, blend blendFunc) color.RGBA {
+	ab := float64(bc.A) / 255
+	back := [3]float64{float64(bc.R) / 255, float64(bc.G) / 255, float64(bc.B) / 255}
+	var out [3]uint8
+	for i := range out {
+		cs := (1-ab)*src[i] + ab*blend(back[i], src[i])
+		// Premultiplied source-over of the blended source onto the backdrop.
+		out[i] = clampByte((as*cs + ab*back[i]*(1-as)) * 255)
+	}
+	return color.RGBA{R: out[0], G: out[1], B: out[2], A: clampByte((as + ab*(1-as)) * 255)}
+}
+
+// blendLayer composites the r part of a premultiplied layer onto dst using
+// blend, with the layer's alpha scaled by opacity.
+func blendLayer(dst draw.Image, layer *image.RGBA, r image.Rectangle, blend blendFunc, opacity float64) {
+	r = r.Intersect(layer.Rect).Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := layer.RGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			a := float64(c.A) / 255
+			src := [3]float64{float64(c.R) / 255 / a, float64(c.G) / 255 / a, float64(c.B) / 255 / a}
+			dst.Set(x, y, blendPixel(color.NRGBAModel.Convert(dst.At(x, y)).(color.NRGBA), src, a*opacity, blend))
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package annotate
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// gaussianBoxes returns the radii of n successive box blurs whose combined
+// variance approximates a Gaussian with the given sigma.
+func gaussianBoxes(sigma float64, n int) []int {
+	ideal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	wl := int(math.Floor(ideal))
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+	m := int(math.Round((12*sigma*sigma - float64(n*wl*wl+4*n*wl+3*n)) / float64(-4*wl-4)))
+	radii := make([]int, n)
+	for i := range radii {
+		w := wu
+		if i < m {
+			w = wl
+		}
+		radii[i] = (w - 1) / 2
+	}
+	return radii
+}
+
+// gaussianBlur blurs r in place with sigma using three box passes per axis.
+// Neighbours outside r but inside the image contribute to the result; at the
+// image edge the window shrinks instead of clamping, so borders don't smear.
+// Only r plus the kernel margin is copied out of dst.
+func gaussianBlur(dst draw.Image, r image.Rectangle, sigma float64) {
+	bounds := dst.Bounds()
+	r = r.Intersect(bounds)
+	if r.Empty() || sigma <= 0 {
+		return
+	}
+	radii := gaussianBoxes(sigma, 3)
+	margin := 0
+	for _, rad := range radii {
+		margin += rad
+	}
+	area := r.Inset(-margin).Intersect(bounds)
+	w, h := area.Dx(), area.Dy()
+	stride := w * 4
+
+	a := make([]uint8, stride*h)
+	if src, ok := dst.(*image.RGBA); ok {
+		for y := 0; y < h; y++ {
+			copy(a[y*stride:(y+1)*stride], src.Pix[src.PixOffset(area.Min.X, area.Min.Y+y):])
+		}
+	} else {
+		tmp := &image.RGBA{Pix: a, Stride: stride, Rect: area}
+		draw.Draw(tmp, area, dst, area.Min, draw.Src)
+	}
+	b := make([]uint8, len(a))
+
+	for _, rad := range radii {
+		for y := 0; y < h; y++ {
+			boxRow(b[y*stride:(y+1)*stride], a[y*stride:(y+1)*stride], rad)
+		}
+		a, b = b, a
+	}
+	// Vertical passes only matter for the columns that end up in r.
+	x0, x1 := (r.Min.X-area.Min.X)*4, (r.Max.X-area.Min.X)*4
+	for _, rad := range radii {
+		boxColumns(b, a, stride, h, x0, x1, rad)
+		a, b = b, a
+	}
+
+	out := &image.RGBA{Pix: a, Stride: stride, Rect: area}
+	if d, ok := dst.(*image.RGBA); ok {
+		n := r.Dx() * 4
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			copy(d.Pix[d.PixOffset(r.Min.X, y):][:n], out.Pix[out.PixOffset(r.Min.X, y):][:n])
+		}
+		return
+	}
+	draw.Draw(dst, r, out, r.Min, draw.Src)
+}
+
+// boxRow writes the running mean of src over a window of 2*rad+1 pixels.
+func boxRow(dst, src []uint8, rad int) {
+	n := len(src) / 4
+	var sum [4]int
+	count := 0
+	for i := 0; i <= rad && i < n; i++ {
+		for c := 0; c < 4; c++ {
+			sum[c] += int(src[i*4+c])
+		}
+		count++
+	}
+	for i := 0; i < n; i++ {
+		for c := 0; c < 4; c++ {
+			dst[i*4+c] = uint8((sum[c] + count/2) / count)
+		}
+		if j := i + rad + 1; j < n {
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src[j*4+c])
+			}
+			count++
+		}
+		if j := i - rad; j >= 0 {
+			for c := 0; c < 4; c++ {
+				sum[c] -= int(src[j*4+c])
+			}
+			count--
+		}
+	}
+}
+
+// boxColumns is boxRow applied down the byte columns [x0, x1) of a buffer
+// with h rows. It walks row by row so memory access stays sequential.
+func boxColumns(dst, src []uint8, stride, h, x0, x1, rad int) {
+	sum := make([]int, x1-x0)
+	count := 0
+	for y := 0; y <= rad && y < h; y++ {
+		row := src[y*stride+x0 : y*stride+x1]
+		for i, v := range row {
+			sum[i] += int(v)
+		}
+		count++
+	}
+	for y := 0; y < h; y++ {
+		out := dst[y*stride+x0 : y*stride+x1]
+		for i, s := range sum {
+			out[i] = uint8((s + count/2) / count)
+		}
+		if j := y + rad + 1; j < h {
+			for i, v := range src[j*stride+x0 : j*stride+x1] {
+				sum[i] += int(v)
+			}
+			count++
+		}
+		if j := y - rad; j >= 0 {
+			for i, v := range src[j*stride+x0 : j*stride+x1] {
+				sum[i] -= int(v)
+			}
+			count--
+		}
+	}
+}
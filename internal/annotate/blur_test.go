@@ -0,0 +1,165 @@
+package annotate
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// blurFixture is a deterministic pattern with hard edges, gradients and a
+// translucent band so the golden files exercise every channel.
+func blurFixture(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 40, A: 255}
+			if (x/8+y/8)%2 == 0 {
+				c.B = 220
+			}
+			if y >= h/2 && y < h/2+6 {
+				c.A = 96
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("write golden: %v", err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open golden (run with -update to create): %v", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decode golden: %v", err)
+	}
+	b := img.Bounds()
+	if want.Bounds().Size() != b.Size() {
+		t.Fatalf("golden size %v, got %v", want.Bounds().Size(), b.Size())
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			got := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y))
+			exp := color.NRGBAModel.Convert(want.At(want.Bounds().Min.X+x, want.Bounds().Min.Y+y))
+			if got != exp {
+				t.Fatalf("pixel (%d,%d) = %v, golden %v", x, y, got, exp)
+			}
+		}
+	}
+}
+
+func TestBlurGolden(t *testing.T) {
+	for _, radius := range []int{1, 4, 12} {
+		t.Run(fmt.Sprintf("radius%d", radius), func(t *testing.T) {
+			img := blurFixture(64, 48)
+			if err := applyBlur(img, BlurPayload{X: 8, Y: 6, W: 44, H: 34, Radius: radius}); err != nil {
+				t.Fatalf("blur: %v", err)
+			}
+			checkGolden(t, fmt.Sprintf("blur_r%d.png", radius), img)
+		})
+	}
+}
+
+func TestBlurOnlyTouchesRegion(t *testing.T) {
+	orig := blurFixture(64, 48)
+	img := blurFixture(64, 48)
+	region := image.Rect(10, 10, 30, 25)
+	if err := applyBlur(img, BlurPayload{X: 10, Y: 10, W: 20, H: 15, Radius: 6}); err != nil {
+		t.Fatalf("blur: %v", err)
+	}
+	changed := false
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			same := img.RGBAAt(x, y) == orig.RGBAAt(x, y)
+			if !image.Pt(x, y).In(region) && !same {
+				t.Fatalf("pixel (%d,%d) outside the region changed", x, y)
+			}
+			changed = changed || !same
+		}
+	}
+	if !changed {
+		t.Fatal("expected the region to be blurred")
+	}
+}
+
+func TestBlurKeepsFlatColorAndOffsetBounds(t *testing.T) {
+	flat := color.RGBA{R: 10, G: 120, B: 200, A: 255}
+	img := image.NewRGBA(image.Rect(-20, -10, 40, 30))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = flat.R, flat.G, flat.B, flat.A
+	}
+	if err := applyBlur(img, BlurPayload{X: -30, Y: -30, W: 100, H: 100, Radius: 9}); err != nil {
+		t.Fatalf("blur: %v", err)
+	}
+	for y := -10; y < 30; y++ {
+		for x := -20; x < 40; x++ {
+			if got := img.RGBAAt(x, y); got != flat {
+				t.Fatalf("flat area changed at (%d,%d): %v", x, y, got)
+			}
+		}
+	}
+}
+
+func TestBlurMatchesForNonRGBADestination(t *testing.T) {
+	rgba := blurFixture(40, 30)
+	other := image.NewRGBA64(rgba.Rect)
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			other.Set(x, y, rgba.At(x, y))
+		}
+	}
+	p := BlurPayload{X: 5, Y: 5, W: 25, H: 20, Radius: 3}
+	if err := applyBlur(rgba, p); err != nil {
+		t.Fatalf("blur: %v", err)
+	}
+	if err := applyBlur(other, p); err != nil {
+		t.Fatalf("blur: %v", err)
+	}
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			if got := color.RGBAModel.Convert(other.At(x, y)); got != rgba.RGBAAt(x, y) {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, rgba.RGBAAt(x, y))
+			}
+		}
+	}
+}
+
+func BenchmarkBlur(b *testing.B) {
+	for _, radius := range []int{3, 20, 60} {
+		b.Run(fmt.Sprintf("1000x600/radius%d", radius), func(b *testing.B) {
+			src := blurFixture(1000, 600)
+			img := image.NewRGBA(src.Rect)
+			p := BlurPayload{X: 0, Y: 0, W: 1000, H: 600, Radius: radius}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(img.Pix, src.Pix)
+				if err := applyBlur(img, p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
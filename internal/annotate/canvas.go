@@ -0,0 +1,88 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+const maxCanvasSide = 16384
+
+// ValidateCanvas checks the crop, pad and resize options of an export request
+// before the base image is decoded.
+func ValidateCanvas(crop *core.Rect, pad *core.Insets, padColor string, resize *core.Size) error {
+	if crop != nil && (crop.W <= 0 || crop.H <= 0) {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "crop must have a positive size"}
+	}
+	if pad != nil && (pad.Top < 0 || pad.Right < 0 || pad.Bottom < 0 || pad.Left < 0 ||
+		pad.Top+pad.Bottom > maxCanvasSide || pad.Left+pad.Right > maxCanvasSide) {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "pad out of range"}
+	}
+	if resize != nil && (resize.Width < 0 || resize.Height < 0 || resize.Width > maxCanvasSide || resize.Height > maxCanvasSide ||
+		resize.Width == 0 && resize.Height == 0) {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "resize out of range"}
+	}
+	return validateColors(padColor)
+}
+
+// NewCanvas returns the surface ops are rendered onto. Its bounds stay in base
+// image coordinates, so a crop moves Min away from the origin and padding may
+// make it negative; op payloads never need remapping.
+func NewCanvas(base image.Image, crop *core.Rect, pad *core.Insets, padColor string) (*image.RGBA, error) {
+	area := base.Bounds()
+	if crop != nil {
+		area = image.Rect(crop.X, crop.Y, crop.X+crop.W, crop.Y+crop.H).Intersect(area)
+		if area.Empty() {
+			return nil, &core.AppError{Code: core.ErrInvalidOpPayload, Message: "crop lies outside the base image"}
+		}
+	}
+	content := area
+	if pad != nil {
+		area = image.Rect(area.Min.X-pad.Left, area.Min.Y-pad.Top, area.Max.X+pad.Right, area.Max.Y+pad.Bottom)
+	}
+	canvas := image.NewRGBA(area)
+	if pad != nil {
+		var fill color.Color = color.Transparent
+		if padColor != "" {
+			c, err := parseColor(padColor)
+			if err != nil {
+				return nil, err
+			}
+			fill = c
+		}
+		draw.Draw(canvas, area, image.NewUniform(fill), image.Point{}, draw.Src)
+	}
+	draw.Draw(canvas, content, base, content.Min, draw.Src)
+	return canvas, nil
+}
+
+// FinishCanvas moves the rendered canvas to the origin and applies resize
+// with Catmull-Rom resampling. A side left at 0 keeps the aspect ratio, and
+// is rejected if that makes it longer than maxCanvasSide.
+func FinishCanvas(canvas *image.RGBA, resize *core.Size) (*image.RGBA, error) {
+	out := &image.RGBA{Pix: canvas.Pix, Stride: canvas.Stride, Rect: image.Rect(0, 0, canvas.Rect.Dx(), canvas.Rect.Dy())}
+	if resize == nil {
+		return out, nil
+	}
+	w, h := float64(resize.Width), float64(resize.Height)
+	switch {
+	case w == 0:
+		w = math.Max(1, math.Round(h*float64(out.Rect.Dx())/float64(out.Rect.Dy())))
+	case h == 0:
+		h = math.Max(1, math.Round(w*float64(out.Rect.Dy())/float64(out.Rect.Dx())))
+	}
+	if w > maxCanvasSide || h > maxCanvasSide {
+		return nil, &core.AppError{Code: core.ErrInvalidOpPayload, Message: "resize out of range"}
+	}
+	if int(w) == out.Rect.Dx() && int(h) == out.Rect.Dy() {
+		return out, nil
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+	xdraw.CatmullRom.Scale(scaled, scaled.Rect, out, out.Rect, xdraw.Src, nil)
+	return scaled, nil
+}
@@ -0,0 +1,210 @@
+package annotate
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+// defaultColor is used when a payload leaves its color empty.
+var defaultColor = color.NRGBA{R: 255, A: 255}
+
+// extraColorNames are CSS names missing from the SVG 1.1 table in colornames.
+var extraColorNames = map[string]color.NRGBA{
+	"transparent":   {},
+	"rebeccapurple": {R: 0x66, G: 0x33, B: 0x99, A: 255},
+}
+
+// parseColor accepts the CSS color forms the frontend can produce: #RGB,
+// #RGBA, #RRGGBB, #RRGGBBAA, rgb()/rgba(), hsl()/hsla() and named colors.
+// The result is non-premultiplied so translucent colors composite correctly
+// through image/draw.
+func parseColor(s string) (color.NRGBA, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if v == "" {
+		return defaultColor, nil
+	}
+	var (
+		c  color.NRGBA
+		ok bool
+	)
+	switch {
+	case strings.HasPrefix(v, "#"):
+		c, ok = parseHexColor(v[1:])
+	case strings.HasPrefix(v, "rgb(") || strings.HasPrefix(v, "rgba("):
+		c, ok = parseRGBFunc(v)
+	case strings.HasPrefix(v, "hsl(") || strings.HasPrefix(v, "hsla("):
+		c, ok = parseHSLFunc(v)
+	default:
+		if named, found := colornames.Map[v]; found {
+			c, ok = color.NRGBA{R: named.R, G: named.G, B: named.B, A: named.A}, true
+		} else {
+			c, ok = extraColorNames[v]
+		}
+	}
+	if !ok {
+		return color.NRGBA{}, &core.AppError{Code: core.ErrInvalidOpPayload, Message: "invalid color: " + strconv.Quote(s)}
+	}
+	return c, nil
+}
+
+func parseHexColor(h string) (color.NRGBA, bool) {
+	switch len(h) {
+	case 3, 4:
+		// Each short-form digit is doubled: #f80 == #ff8800.
+		var long strings.Builder
+		for _, r := range h {
+			long.WriteRune(r)
+			long.WriteRune(r)
+		}
+		h = long.String()
+	case 6, 8:
+	default:
+		return color.NRGBA{}, false
+	}
+	n, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	if len(h) == 6 {
+		n = n<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}, true
+}
+
+// colorArgs splits "name(a, b, c, d)" or "name(a b c / d)" into its
+// arguments. It reports false unless there are three or four of them.
+func colorArgs(v string) ([]string, bool) {
+	open := strings.IndexByte(v, '(')
+	if open < 0 || !strings.HasSuffix(v, ")") {
+		return nil, false
+	}
+	body := v[open+1 : len(v)-1]
+	var args []string
+	if strings.Contains(body, ",") {
+		args = strings.Split(body, ",")
+	} else {
+		body = strings.Replace(body, "/", " / ", 1)
+		for _, f := range strings.Fields(body) {
+			if f != "/" {
+				args = append(args, f)
+			}
+		}
+	}
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args, len(args) == 3 || len(args) == 4
+}
+
+func parseRGBFunc(v string) (color.NRGBA, bool) {
+	args, ok := colorArgs(v)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	var ch [3]uint8
+	for i := range ch {
+		f, ok := parseNumberOrPercent(args[i], 255)
+		if !ok {
+			return color.NRGBA{}, false
+		}
+		ch[i] = clampByte(f)
+	}
+	a, ok := parseAlpha(args)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: ch[0], G: ch[1], B: ch[2], A: a}, true
+}
+
+func parseHSLFunc(v string) (color.NRGBA, bool) {
+	args, ok := colorArgs(v)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	h, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "deg"), 64)
+	if err != nil || math.IsNaN(h) || math.IsInf(h, 0) {
+		return color.NRGBA{}, false
+	}
+	if !strings.HasSuffix(args[1], "%") || !strings.HasSuffix(args[2], "%") {
+		return color.NRGBA{}, false
+	}
+	s, ok1 := parseNumberOrPercent(args[1], 1)
+	l, ok2 := parseNumberOrPercent(args[2], 1)
+	a, ok3 := parseAlpha(args)
+	if !ok1 || !ok2 || !ok3 {
+		return color.NRGBA{}, false
+	}
+	s = math.Max(0, math.Min(1, s))
+	l = math.Max(0, math.Min(1, l))
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+
+	// CSS Color 3 HSL-to-RGB algorithm.
+	var m2 float64
+	if l <= 0.5 {
+		m2 = l * (s + 1)
+	} else {
+		m2 = l + s - l*s
+	}
+	m1 := l*2 - m2
+	return color.NRGBA{
+		R: clampByte(hueToRGB(m1, m2, h+1.0/3) * 255),
+		G: clampByte(hueToRGB(m1, m2, h) * 255),
+		B: clampByte(hueToRGB(m1, m2, h-1.0/3) * 255),
+		A: a,
+	}, true
+}
+
+func hueToRGB(m1, m2, h float64) float64 {
+	if h < 0 {
+		h++
+	}
+	if h > 1 {
+		h--
+	}
+	switch {
+	case h*6 < 1:
+		return m1 + (m2-m1)*h*6
+	case h*2 < 1:
+		return m2
+	case h*3 < 2:
+		return m1 + (m2-m1)*(2.0/3-h)*6
+	}
+	return m1
+}
+
+// parseAlpha reads the optional fourth argument as 0-1 or a percentage.
+func parseAlpha(args []string) (uint8, bool) {
+	if len(args) < 4 {
+		return 255, true
+	}
+	a, ok := parseNumberOrPercent(args[3], 1)
+	if !ok {
+		return 0, false
+	}
+	return clampByte(math.Max(0, math.Min(1, a)) * 255), true
+}
+
+// parseNumberOrPercent parses a plain number, or a percentage scaled so that
+// 100% equals full.
+func parseNumberOrPercent(s string, full float64) (float64, bool) {
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = strings.TrimSuffix(s, "%")
+		scale = full / 100
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f * scale, true
+}
+
+func clampByte(f float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(f))))
+}
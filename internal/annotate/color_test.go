@@ -0,0 +1,64 @@
+package annotate
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+func TestParseColorFormats(t *testing.T) {
+	cases := map[string]color.NRGBA{
+		"#f80":                       {R: 0xff, G: 0x88, B: 0x00, A: 0xff},
+		"#f808":                      {R: 0xff, G: 0x88, B: 0x00, A: 0x88},
+		"#FF3B30":                    {R: 0xff, G: 0x3b, B: 0x30, A: 0xff},
+		"#ff3b3080":                  {R: 0xff, G: 0x3b, B: 0x30, A: 0x80},
+		"rgb(255, 59, 48)":           {R: 255, G: 59, B: 48, A: 255},
+		"rgba(255,59,48,0.5)":        {R: 255, G: 59, B: 48, A: 128},
+		"rgb(100% 0% 0% / 25%)":      {R: 255, A: 64},
+		"hsl(120, 100%, 50%)":        {G: 255, A: 255},
+		"hsla(240deg, 100%, 50%, 1)": {B: 255, A: 255},
+		"hsl(0, 0%, 50%)":            {R: 128, G: 128, B: 128, A: 255},
+		"  RebeccaPurple ":           {R: 0x66, G: 0x33, B: 0x99, A: 255},
+		"orange":                     {R: 0xff, G: 0xa5, A: 255},
+		"transparent":                {},
+		"":                           defaultColor,
+	}
+	for in, want := range cases {
+		got, err := parseColor(in)
+		if err != nil {
+			t.Fatalf("parse %q: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("parse %q: expected %v, got %v", in, want, got)
+		}
+	}
+}
+
+func TestParseColorRejectsMalformedInput(t *testing.T) {
+	for _, in := range []string{"#12", "#ggg", "#1234567", "rgb(1,2)", "rgb(a,b,c)", "hsl(10, 50, 50)", "notacolor", "rgba(1,2,3,4,5)"} {
+		_, err := parseColor(in)
+		var appErr *core.AppError
+		if !errors.As(err, &appErr) || appErr.Code != core.ErrInvalidOpPayload {
+			t.Fatalf("parse %q: expected %s, got %v", in, core.ErrInvalidOpPayload, err)
+		}
+	}
+}
+
+func TestRenderTranslucentFillBlendsWithBase(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	op := core.AnnotationOp{ID: "1", Kind: "rect", Payload: json.RawMessage(`{"x":0,"y":0,"w":10,"h":10,"color":"rgba(0,0,255,0.5)","fill":true}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	got := img.RGBAAt(5, 5)
+	if got.R < 120 || got.R > 135 || got.B != 255 || got.A != 255 {
+		t.Fatalf("expected half-blended blue over white, got %v", got)
+	}
+}
@@ -0,0 +1,106 @@
+package annotate
+
+import (
+	"bytes"
+	"embed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+// The cursor bitmaps are drawn for a 2x display, so a Retina capture gets
+// them pixel for pixel and other scales resample them.
+const cursorAssetScale = 2
+
+//go:embed cursors/*.png
+var cursorFiles embed.FS
+
+// cursorAsset is an embedded cursor image and its hotspot in image pixels.
+type cursorAsset struct {
+	file    string
+	hotspot image.Point
+}
+
+var cursorAssets = map[string]cursorAsset{
+	"arrow": {file: "cursors/arrow.png", hotspot: image.Pt(3, 3)},
+	"ibeam": {file: "cursors/ibeam.png", hotspot: image.Pt(9, 18)},
+}
+
+var cursorImages = sync.OnceValues(func() (map[string]image.Image, error) {
+	images := make(map[string]image.Image, len(cursorAssets))
+	for name, a := range cursorAssets {
+		data, err := cursorFiles.ReadFile(a.file)
+		if err != nil {
+			return nil, err
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		images[name] = img
+	}
+	return images, nil
+})
+
+func renderCursor(dst draw.Image, p CursorPayload, displayScale int) error {
+	shape := p.Shape
+	if shape == "" {
+		shape = "arrow"
+	}
+	images, err := cursorImages()
+	if err != nil {
+		return &core.AppError{Code: core.ErrRenderFailed, Message: "load cursor: " + err.Error()}
+	}
+	src := images[shape]
+	hot := cursorAssets[shape].hotspot
+
+	k := p.Scale
+	if k == 0 {
+		k = 1
+	}
+	k *= float64(max(displayScale, 1)) / cursorAssetScale
+	if k == 1 {
+		at := image.Pt(p.X, p.Y).Sub(hot)
+		draw.Draw(dst, src.Bounds().Add(at), src, image.Point{}, draw.Over)
+		return nil
+	}
+	b := src.Bounds()
+	x0 := float64(p.X) - float64(hot.X)*k
+	y0 := float64(p.Y) - float64(hot.Y)*k
+	r := image.Rect(int(math.Round(x0)), int(math.Round(y0)), int(math.Round(x0+float64(b.Dx())*k)), int(math.Round(y0+float64(b.Dy())*k)))
+	xdraw.CatmullRom.Scale(dst, r, src, b, xdraw.Over, nil)
+	return nil
+}
+
+// renderClick draws a solid dot inside two rings that fade as they widen,
+// like the ripple screen recorders show on a click.
+func renderClick(dst draw.Image, p ClickPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	radius := float64(p.Radius)
+	if radius <= 0 {
+		radius = 16
+	}
+	faded := func(k float64) color.NRGBA {
+		f := c
+		f.A = clampByte(float64(c.A) * k)
+		return f
+	}
+	center := fpoint{float64(p.X), float64(p.Y)}
+	width := max(2, int(math.Round(radius/8)))
+	ring := newStrokeStyle(width, "", "", nil)
+	for _, r := range []struct{ radius, alpha float64 }{{radius - float64(width)/2, 0.35}, {radius * 0.62, 0.7}} {
+		strokePath(dst, ellipsePoints(center, r.radius, r.radius), true, ring, faded(r.alpha))
+	}
+	fillPolygons(dst, [][]fpoint{discPoints(center, radius*0.3)}, fillNonZero, faded(0.9))
+	return nil
+}
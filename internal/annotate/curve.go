@@ -0,0 +1,101 @@
+package annotate
+
+import (
+	"image/draw"
+	"math"
+)
+
+// maxCurveSegments bounds how finely a single Bézier is flattened.
+const maxCurveSegments = 1024
+
+// bezierPoints flattens the quadratic Bézier from a to b through c1, or the
+// cubic one when c2 is set. Segments are about two pixels long along the
+// control polygon, which is finer than the anti-aliasing can resolve.
+func bezierPoints(a, c1 fpoint, c2 *fpoint, b fpoint) []fpoint {
+	ctrl := dist(a, c1) + dist(c1, b)
+	if c2 != nil {
+		ctrl = dist(a, c1) + dist(c1, *c2) + dist(*c2, b)
+	}
+	n := int(math.Ceil(ctrl / 2))
+	n = min(max(n, 8), maxCurveSegments)
+	pts := make([]fpoint, 0, n+1)
+	for i := 0; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		var p fpoint
+		if c2 == nil {
+			p = a.scale(u * u).add(c1.scale(2 * u * t)).add(b.scale(t * t))
+		} else {
+			p = a.scale(u * u * u).add(c1.scale(3 * u * u * t)).add(c2.scale(3 * u * t * t)).add(b.scale(t * t * t))
+		}
+		pts = append(pts, p)
+	}
+	return pts
+}
+
+func dist(a, b fpoint) float64 {
+	return math.Hypot(b.x-a.x, b.y-a.y)
+}
+
+// endDirection is the unit direction the polyline travels as it reaches its
+// last point, skipping zero-length segments. ok is false for a single point.
+func endDirection(pts []fpoint) (fpoint, bool) {
+	end := pts[len(pts)-1]
+	for i := len(pts) - 2; i >= 0; i-- {
+		if d := end.sub(pts[i]); d.x != 0 || d.y != 0 {
+			return unit(d), true
+		}
+	}
+	return fpoint{}, false
+}
+
+// headDirection aims an arrow head of the given size along the chord over
+// the last size pixels of the path, so a tight hook at the very end of a
+// curve doesn't twist the head away from the visible approach.
+func headDirection(pts []fpoint, size float64) (fpoint, bool) {
+	trimmed := trimEnd(pts, size)
+	if d := pts[len(pts)-1].sub(trimmed[len(trimmed)-1]); d.x != 0 || d.y != 0 {
+		return unit(d), true
+	}
+	return endDirection(pts)
+}
+
+// trimEnd shortens the polyline by length measured back from its last point.
+func trimEnd(pts []fpoint, length float64) []fpoint {
+	for i := len(pts) - 1; i > 0; i-- {
+		seg := dist(pts[i-1], pts[i])
+		if seg >= length {
+			out := append([]fpoint(nil), pts[:i]...)
+			return append(out, pts[i].add(unit(pts[i-1].sub(pts[i])).scale(length)))
+		}
+		length -= seg
+	}
+	return pts[:1]
+}
+
+func reversed(pts []fpoint) []fpoint {
+	out := make([]fpoint, len(pts))
+	for i, p := range pts {
+		out[len(pts)-1-i] = p
+	}
+	return out
+}
+
+func renderCurve(dst draw.Image, p CurvePayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	strokePath(dst, p.path(), false, newStrokeStyle(p.StrokeWidth, p.Cap, p.Join, p.Dash), c)
+	return nil
+}
+
+// headWings returns the two barb ends of an arrow head of the given size
+// whose tip is at tip and which points along dir.
+func headWings(tip, dir fpoint, size float64) (fpoint, fpoint) {
+	angle := math.Atan2(dir.y, dir.x)
+	a1 := angle + math.Pi*0.82
+	a2 := angle - math.Pi*0.82
+	return tip.add(fpoint{math.Cos(a1), math.Sin(a1)}.scale(size)),
+		tip.add(fpoint{math.Cos(a2), math.Sin(a2)}.scale(size))
+}
@@ -0,0 +1,98 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// polygon outlines the region in path coordinates.
+func (r Region) polygon() []fpoint {
+	if r.Shape == "ellipse" {
+		return ellipsePoints(fpoint{float64(r.X) + float64(r.W)/2, float64(r.Y) + float64(r.H)/2}, float64(r.W)/2, float64(r.H)/2)
+	}
+	return rectPoints(r.X, r.Y, r.W, r.H)
+}
+
+// regionsMask rasterizes the union of regions as anti-aliased coverage.
+func regionsMask(regions []Region, clip image.Rectangle) *image.Alpha {
+	polys := make([][]fpoint, 0, len(regions))
+	for _, r := range regions {
+		if r.W > 0 && r.H > 0 {
+			polys = append(polys, r.polygon())
+		}
+	}
+	return rasterize(polys, fillNonZero, clip)
+}
+
+// coverageAt reads mask coverage in [0, 1]; a nil mask covers nothing.
+func coverageAt(mask *image.Alpha, x, y int) float64 {
+	if mask == nil || !image.Pt(x, y).In(mask.Rect) {
+		return 0
+	}
+	return float64(mask.Pix[mask.PixOffset(x, y)]) / 255
+}
+
+// luma is the Rec. 601 brightness of a premultiplied pixel.
+func luma(c color.RGBA) uint8 {
+	return uint8((299*int(c.R) + 587*int(c.G) + 114*int(c.B) + 500) / 1000)
+}
+
+func lerpRGBA(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return clampByte(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: mix(a.A, b.A)}
+}
+
+// applySpotlight is the inverse of the region effects: the same treatment
+// blur and pixelate give the inside of a rectangle is given to everything
+// outside the spotlight regions, with anti-aliased region edges.
+func applySpotlight(dst draw.Image, p SpotlightPayload) error {
+	if p.Color == "" {
+		p.Color = "#000000"
+	}
+	dimColor, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	dim := 0.6
+	if p.Dim != nil {
+		dim = *p.Dim
+	}
+	bounds := dst.Bounds()
+	keep := regionsMask(p.Regions, bounds)
+
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, dst, bounds.Min, draw.Src)
+	treated := src
+	if p.Blur > 0 {
+		treated = image.NewRGBA(bounds)
+		draw.Draw(treated, bounds, src, bounds.Min, draw.Src)
+		if err := applyBlur(treated, BlurPayload{X: bounds.Min.X, Y: bounds.Min.Y, W: bounds.Dx(), H: bounds.Dy(), Radius: p.Blur}); err != nil {
+			return err
+		}
+	}
+	// Dimming is source-over of the dim color at the dim fraction of its alpha.
+	da := dim * float64(dimColor.A) / 255
+	tint := [3]float64{float64(dimColor.R) * da, float64(dimColor.G) * da, float64(dimColor.B) * da}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			outside := 1 - coverageAt(keep, x, y)
+			if outside == 0 {
+				continue
+			}
+			c := treated.RGBAAt(x, y)
+			if p.Desaturate {
+				l := luma(c)
+				c.R, c.G, c.B = l, l, l
+			}
+			c = color.RGBA{
+				R: clampByte(tint[0] + float64(c.R)*(1-da)),
+				G: clampByte(tint[1] + float64(c.G)*(1-da)),
+				B: clampByte(tint[2] + float64(c.B)*(1-da)),
+				A: clampByte(da*255 + float64(c.A)*(1-da)),
+			}
+			dst.Set(x, y, lerpRGBA(src.RGBAAt(x, y), c, outside))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package annotate
+
+import (
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+const defaultKeysTextSize = 14
+
+// keyTheme colors a keycap: face is the top, edge the border and the deeper
+// bottom lip, sep the plus signs between caps.
+type keyTheme struct {
+	face, edge, text, sep color.NRGBA
+}
+
+var keyThemes = map[string]keyTheme{
+	"light": {
+		face: color.NRGBA{R: 0xf8, G: 0xfa, B: 0xfc, A: 0xff},
+		edge: color.NRGBA{R: 0x94, G: 0xa3, B: 0xb8, A: 0xff},
+		text: color.NRGBA{R: 0x0f, G: 0x17, B: 0x2a, A: 0xff},
+		sep:  color.NRGBA{R: 0x47, G: 0x55, B: 0x69, A: 0xff},
+	},
+	"dark": {
+		face: color.NRGBA{R: 0x33, G: 0x41, B: 0x55, A: 0xff},
+		edge: color.NRGBA{R: 0x0f, G: 0x17, B: 0x2a, A: 0xff},
+		text: color.NRGBA{R: 0xf1, G: 0xf5, B: 0xf9, A: 0xff},
+		sep:  color.NRGBA{R: 0xcb, G: 0xd5, B: 0xe1, A: 0xff},
+	},
+}
+
+// splitKeys splits a shortcut on "+", reading an empty key followed by
+// another empty one as the plus key itself, so "Ctrl++" is Ctrl and +.
+func splitKeys(s string) []string {
+	parts := strings.Split(s, "+")
+	var keys []string
+	for i := 0; i < len(parts); i++ {
+		k := strings.TrimSpace(parts[i])
+		if k == "" && i+1 < len(parts) && strings.TrimSpace(parts[i+1]) == "" {
+			k = "+"
+			i++
+		}
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// renderKeys lays the caps out left to right. Every cap is as tall as the
+// font's ascent plus descent with padding, and at least as wide as it is
+// tall, so single letters come out square.
+func renderKeys(dst draw.Image, p KeysPayload) error {
+	theme := keyThemes[p.Theme]
+	if p.Theme == "" {
+		theme = keyThemes["light"]
+	}
+	size := p.Size
+	if size <= 0 {
+		size = defaultKeysTextSize
+	}
+	padX := math.Round(float64(size) * 0.5)
+	padY := math.Round(float64(size) * 0.3)
+	depth := math.Max(2, math.Round(float64(size)/7))
+	gap := math.Round(float64(size) * 0.3)
+	radius := math.Max(3, math.Round(float64(size)/3))
+
+	x := float64(p.X)
+	y0 := float64(p.Y)
+	for i, key := range splitKeys(p.Keys) {
+		if i > 0 {
+			sep, err := newTextBlock("+", size, true)
+			if err != nil {
+				return err
+			}
+			x += gap
+			h := float64(sep.ascent+sep.descent) + 2*padY
+			sep.drawCentered(dst, fpoint{x + float64(sep.width)/2, y0 + h/2}, theme.sep)
+			x += float64(sep.width) + gap
+			sep.close()
+		}
+
+		b, err := newTextBlock(key, size, false)
+		if err != nil {
+			return err
+		}
+		h := float64(b.ascent+b.descent) + 2*padY
+		w := math.Max(float64(b.width)+2*padX, h)
+		fillPolygons(dst, [][]fpoint{roundedRectPoints(x, y0, x+w, y0+h+depth, radius)}, fillNonZero, theme.edge)
+		fillPolygons(dst, [][]fpoint{roundedRectPoints(x+1, y0+1, x+w-1, y0+h, radius-1)}, fillNonZero, theme.face)
+		left := int(math.Round(x + (w-float64(b.width))/2))
+		b.draw(dst, left, int(y0+padY)+b.ascent, theme.text)
+		b.close()
+		x += w
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+// needsLayer reports whether op has to be drawn on its own layer before it
+// reaches the canvas, rather than straight onto it.
+func needsLayer(op core.AnnotationOp) bool {
+	return op.Shadow != nil || op.Outline != nil || op.Opacity != nil || op.Blend != ""
+}
+
+func validateCompositing(op core.AnnotationOp) error {
+	if op.Opacity == nil && op.Blend == "" {
+		return nil
+	}
+	// A translucent or blended redaction would let the hidden pixels through.
+	if op.Kind == "redact" {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "redactions cannot set opacity or blend: " + op.ID}
+	}
+	if op.Opacity != nil && !(*op.Opacity >= 0 && *op.Opacity <= 1) {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "opacity must be between 0 and 1: " + op.ID}
+	}
+	if _, ok := knownBlends[op.Blend]; op.Blend != "" && !ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported blend mode: " + op.Blend}
+	}
+	return nil
+}
+
+// renderLayered draws op with its shadow and outline into a scratch layer and
+// composites the whole group onto dst with the op's blend and opacity. Pixel
+// effects start from a copy of dst, since they transform what is already
+// there, and only the pixels they change are composited; everything else
+// starts from a transparent layer.
+func renderLayered(dst draw.Image, op core.AnnotationOp, opts RenderOptions) error {
+	if err := validateDecorations(op); err != nil {
+		return err
+	}
+	if err := validateCompositing(op); err != nil {
+		return err
+	}
+	bounds := dst.Bounds()
+	layer := image.NewRGBA(bounds)
+	area := bounds
+	if _, ok := pixelEffectKinds[op.Kind]; ok {
+		draw.Draw(layer, bounds, dst, bounds.Min, draw.Src)
+		if err := renderOp(layer, op, opts); err != nil {
+			return err
+		}
+		area = keepChanged(layer, dst)
+		if area.Empty() {
+			return nil
+		}
+	} else {
+		var err error
+		if op.Transform != nil {
+			err = renderTransformed(layer, op, opts)
+		} else {
+			err = renderOp(layer, op, opts)
+		}
+		if err != nil {
+			return err
+		}
+		ink := inkBounds(layer)
+		if ink.Empty() {
+			return nil
+		}
+		area = ink
+		if op.Shadow != nil || op.Outline != nil {
+			group := image.NewRGBA(bounds)
+			if op.Shadow != nil {
+				if err := drawShadow(group, layer, ink, op.Shadow); err != nil {
+					return err
+				}
+			}
+			if op.Outline != nil {
+				if err := drawOutline(group, layer, ink, op.Outline); err != nil {
+					return err
+				}
+			}
+			draw.Draw(group, ink, layer, ink.Min, draw.Over)
+			layer = group
+			area = inkBounds(group)
+		}
+	}
+
+	opacity := 1.0
+	if op.Opacity != nil {
+		opacity = *op.Opacity
+	}
+	blend, ok := knownBlends[op.Blend]
+	if !ok {
+		blend, ok = layerBlends[op.Kind]
+	}
+	if !ok && opacity == 1 {
+		draw.Draw(dst, area, layer, area.Min, draw.Over)
+		return nil
+	}
+	if !ok {
+		blend = blendNormal
+	}
+	blendLayer(dst, layer, area, blend, opacity)
+	return nil
+}
+
+// keepChanged clears every layer pixel that still matches dst, so blending a
+// pixel effect's layer leaves the canvas outside the effect alone, and
+// returns the bounds of what is left.
+func keepChanged(layer *image.RGBA, dst image.Image) image.Rectangle {
+	for y := layer.Rect.Min.Y; y < layer.Rect.Max.Y; y++ {
+		for x := layer.Rect.Min.X; x < layer.Rect.Max.X; x++ {
+			i := layer.PixOffset(x, y)
+			if color.RGBAModel.Convert(dst.At(x, y)).(color.RGBA) == layer.RGBAAt(x, y) {
+				layer.Pix[i], layer.Pix[i+1], layer.Pix[i+2], layer.Pix[i+3] = 0, 0, 0, 0
+			}
+		}
+	}
+	return inkBounds(layer)
+}
@@ -0,0 +1,91 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// polygon outlines the mask in path coordinates. box is the effect's box,
+// used by ellipse masks.
+func (m *EffectMask) polygon(box image.Rectangle) []fpoint {
+	switch m.Shape {
+	case "ellipse":
+		c := fpoint{float64(box.Min.X) + float64(box.Dx())/2, float64(box.Min.Y) + float64(box.Dy())/2}
+		return ellipsePoints(c, float64(box.Dx())/2, float64(box.Dy())/2)
+	case "path":
+		return catmullRom(simplifyRDP(toFloatPoints(m.Points), 1.5), 8)
+	}
+	return toFloatPoints(m.Points)
+}
+
+// polyBounds is the smallest pixel rectangle containing pts.
+func polyBounds(pts []fpoint) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range pts {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
+
+// applyMasked runs effect on a copy of the masked area and blends the result
+// back through the mask's coverage, so pixels outside the shape keep their
+// values. margin is how far beyond its region the effect reads.
+func applyMasked(dst draw.Image, box image.Rectangle, m *EffectMask, margin int, effect func(draw.Image, image.Rectangle)) error {
+	if m.Shape == "ellipse" && box.Empty() {
+		return nil
+	}
+	poly := m.polygon(box)
+	if m.Shape != "ellipse" {
+		box = polyBounds(poly)
+	}
+	// A Gaussian feather reaches about two feather widths past the edge.
+	bounds := dst.Bounds()
+	area := box.Inset(-2 * m.Feather).Intersect(bounds)
+	if area.Empty() {
+		return nil
+	}
+	cov := rasterize([][]fpoint{poly}, fillNonZero, area)
+	if cov == nil {
+		return nil
+	}
+	weights := image.NewAlpha(area)
+	draw.Draw(weights, cov.Rect, cov, cov.Rect.Min, draw.Src)
+	if m.Feather > 0 {
+		featherMask(weights, float64(m.Feather)/2)
+	}
+
+	treated := image.NewRGBA(area.Inset(-margin).Intersect(bounds))
+	draw.Draw(treated, treated.Rect, dst, treated.Rect.Min, draw.Src)
+	effect(treated, area)
+
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			w := weights.Pix[weights.PixOffset(x, y)]
+			switch w {
+			case 0:
+			case 255:
+				dst.Set(x, y, treated.RGBAAt(x, y))
+			default:
+				orig := color.RGBAModel.Convert(dst.At(x, y)).(color.RGBA)
+				dst.Set(x, y, lerpRGBA(orig, treated.RGBAAt(x, y), float64(w)/255))
+			}
+		}
+	}
+	return nil
+}
+
+// featherMask softens mask edges with a Gaussian of the given sigma.
+func featherMask(mask *image.Alpha, sigma float64) {
+	tmp := image.NewRGBA(mask.Rect)
+	for i, a := range mask.Pix {
+		tmp.Pix[i*4+3] = a
+	}
+	gaussianBlur(tmp, tmp.Rect, sigma)
+	for i := range mask.Pix {
+		mask.Pix[i] = tmp.Pix[i*4+3]
+	}
+}
@@ -0,0 +1,73 @@
+package annotate
+
+import (
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const defaultMeasureTextSize = 12
+
+// measureLabel formats the distance between the payload's end points to one
+// decimal place, dropping a trailing ".0".
+func measureLabel(p MeasurePayload, scale int) string {
+	if p.Label != "" {
+		return p.Label
+	}
+	d := math.Hypot(float64(p.X2-p.X1), float64(p.Y2-p.Y1))
+	unit := "px"
+	if p.Unit == "pt" {
+		unit = "pt"
+		if scale > 1 {
+			d /= float64(scale)
+		}
+	}
+	return strconv.FormatFloat(math.Round(d*10)/10, 'f', -1, 64) + " " + unit
+}
+
+// renderMeasure fills the line, its ticks and the label pill as one shape so
+// a translucent color doesn't darken where they overlap.
+func renderMeasure(dst draw.Image, p MeasurePayload, scale int) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	if p.TextColor == "" {
+		p.TextColor = "#ffffff"
+	}
+	tc, err := parseColor(p.TextColor)
+	if err != nil {
+		return err
+	}
+	size := p.Size
+	if size <= 0 {
+		size = defaultMeasureTextSize
+	}
+
+	a := fpoint{float64(p.X1), float64(p.Y1)}
+	b := fpoint{float64(p.X2), float64(p.Y2)}
+	style := newStrokeStyle(p.StrokeWidth, capButt, joinMiter, nil)
+	dir := unit(b.sub(a))
+	if dir == (fpoint{}) {
+		dir = fpoint{1, 0}
+	}
+	tick := perp(dir).scale(math.Max(6, 3*style.width))
+	var shapes [][]fpoint
+	shapes = append(shapes, style.outline([]fpoint{a, b}, false)...)
+	shapes = append(shapes, style.outline([]fpoint{a.add(tick), a.sub(tick)}, false)...)
+	shapes = append(shapes, style.outline([]fpoint{b.add(tick), b.sub(tick)}, false)...)
+
+	block, err := newTextBlock(measureLabel(p, scale), size, false)
+	if err != nil {
+		return err
+	}
+	defer block.close()
+	mid := a.add(b).scale(0.5)
+	halfW := float64(block.width)/2 + float64(size)/2
+	halfH := float64(block.ascent+block.descent)/2 + float64(size)/4
+	shapes = append(shapes, roundedRectPoints(mid.x-halfW, mid.y-halfH, mid.x+halfW, mid.y+halfH, halfH))
+
+	fillPolygons(dst, shapes, fillNonZero, c)
+	block.drawCentered(dst, mid, tc)
+	return nil
+}
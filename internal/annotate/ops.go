@@ -2,6 +2,7 @@ package annotate
 
 import (
 	"encoding/json"
+	"math"
 	"sort"
 
 	"github.com/mohamoundaljadan/screenshot/internal/core"
@@ -12,7 +13,23 @@ type Point struct {
 	Y int `json:"y"`
 }
 
+// RectPayload outlines a box, or fills it when Fill is set. Dash holds
+// canvas-style alternating on/off lengths in pixels; Cap (butt, round, square)
+// and Join (miter, round, bevel) default to butt and miter like the canvas.
 type RectPayload struct {
+	X           int       `json:"x"`
+	Y           int       `json:"y"`
+	W           int       `json:"w"`
+	H           int       `json:"h"`
+	Color       string    `json:"color"`
+	StrokeWidth int       `json:"strokeWidth"`
+	Fill        bool      `json:"fill"`
+	Dash        []float64 `json:"dash,omitempty"`
+	Cap         string    `json:"cap,omitempty"`
+	Join        string    `json:"join,omitempty"`
+}
+
+type EllipsePayload struct {
 	X           int    `json:"x"`
 	Y           int    `json:"y"`
 	W           int    `json:"w"`
@@ -20,53 +37,372 @@ type RectPayload struct {
 	Color       string `json:"color"`
 	StrokeWidth int    `json:"strokeWidth"`
 	Fill        bool   `json:"fill"`
+	FillColor   string `json:"fillColor,omitempty"`
 }
 
+// LinePayload accepts the same Dash, Cap and Join fields as RectPayload.
 type LinePayload struct {
-	X1          int    `json:"x1"`
-	Y1          int    `json:"y1"`
-	X2          int    `json:"x2"`
-	Y2          int    `json:"y2"`
-	Color       string `json:"color"`
-	StrokeWidth int    `json:"strokeWidth"`
+	X1          int       `json:"x1"`
+	Y1          int       `json:"y1"`
+	X2          int       `json:"x2"`
+	Y2          int       `json:"y2"`
+	Color       string    `json:"color"`
+	StrokeWidth int       `json:"strokeWidth"`
+	Dash        []float64 `json:"dash,omitempty"`
+	Cap         string    `json:"cap,omitempty"`
+	Join        string    `json:"join,omitempty"`
 }
 
-type ArrowPayload struct {
+// CurvePayload strokes a quadratic Bézier from (X1, Y1) to (X2, Y2) bent
+// towards the control point C1, or a cubic one when C2 is also set.
+type CurvePayload struct {
 	LinePayload
-	HeadSize int `json:"headSize"`
+	C1 *Point `json:"c1,omitempty"`
+	C2 *Point `json:"c2,omitempty"`
 }
 
+// path flattens the curve, or returns the straight segment when C1 is unset.
+func (p CurvePayload) path() []fpoint {
+	a := fpoint{float64(p.X1), float64(p.Y1)}
+	b := fpoint{float64(p.X2), float64(p.Y2)}
+	if p.C1 == nil {
+		return []fpoint{a, b}
+	}
+	var c2 *fpoint
+	if p.C2 != nil {
+		c2 = &fpoint{float64(p.C2.X), float64(p.C2.Y)}
+	}
+	return bezierPoints(a, fpoint{float64(p.C1.X), float64(p.C1.Y)}, c2, b)
+}
+
+// ArrowPayload is a line, or a curve when C1 is set, ending in a head
+// HeadSize pixels long (default 14). Head is "open" (default), "triangle",
+// "double" (triangles at both ends), "dot" or "none".
+type ArrowPayload struct {
+	CurvePayload
+	HeadSize int    `json:"headSize"`
+	Head     string `json:"head,omitempty"`
+}
+
+// PenPayload is a freehand stroke. When Smooth is set the path is simplified
+// with Tolerance (pixels, default 1.5) and re-interpolated as a Catmull-Rom
+// spline before rendering.
+type PenPayload struct {
+	Points      []Point `json:"points"`
+	Color       string  `json:"color"`
+	StrokeWidth int     `json:"strokeWidth"`
+	Smooth      bool    `json:"smooth"`
+	Tolerance   float64 `json:"tolerance,omitempty"`
+}
+
+// PolygonPayload connects Points in order and closes the outline back to the
+// first point unless Open is set. With Fill the interior is painted in
+// FillColor (default Color) using FillRule, "nonzero" (default) or "evenodd";
+// open polygons are filled as if closed, like the canvas. Dash, Cap and Join
+// work as in RectPayload.
+type PolygonPayload struct {
+	Points      []Point   `json:"points"`
+	Open        bool      `json:"open,omitempty"`
+	Color       string    `json:"color"`
+	StrokeWidth int       `json:"strokeWidth"`
+	Fill        bool      `json:"fill"`
+	FillColor   string    `json:"fillColor,omitempty"`
+	FillRule    string    `json:"fillRule,omitempty"`
+	Dash        []float64 `json:"dash,omitempty"`
+	Cap         string    `json:"cap,omitempty"`
+	Join        string    `json:"join,omitempty"`
+}
+
+// TextPayload places Text with its first baseline at (X, Y). Size is the font
+// size in pixels (default 18) and newlines start additional lines. When
+// Background is set a box is drawn behind the text, Padding pixels (default 4)
+// larger than the glyphs. The embedded font covers Latin, Greek and Cyrillic
+// with common punctuation and symbols; characters outside it, such as CJK,
+// Arabic or dingbats like ✓, render as empty boxes.
 type TextPayload struct {
+	X          int    `json:"x"`
+	Y          int    `json:"y"`
+	Text       string `json:"text"`
+	Color      string `json:"color"`
+	Size       int    `json:"size"`
+	Bold       bool   `json:"bold,omitempty"`
+	Background string `json:"background,omitempty"`
+	Padding    int    `json:"padding,omitempty"`
+}
+
+// HighlightPayload is a translucent marker that multiplies with the pixels
+// underneath, so text below it stays readable. With Points it is a freehand
+// stroke StrokeWidth wide (default 18); otherwise it covers the X/Y/W/H box.
+type HighlightPayload struct {
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	W           int     `json:"w"`
+	H           int     `json:"h"`
+	Points      []Point `json:"points,omitempty"`
+	Color       string  `json:"color"`
+	StrokeWidth int     `json:"strokeWidth"`
+}
+
+// StepPayload is a numbered badge centred on (X, Y). A zero Number is filled
+// in by SortOps, continuing from the previous step in draw order.
+type StepPayload struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Number    int    `json:"number,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	Color     string `json:"color"`
+	TextColor string `json:"textColor,omitempty"`
+}
+
+// CalloutPayload is a rounded text box with its top-left corner at (X, Y).
+// When Anchor is set the box points at it, either with a speech-bubble tail
+// (the default) or with a leader line ending in a dot.
+type CalloutPayload struct {
+	X           int    `json:"x"`
+	Y           int    `json:"y"`
+	Text        string `json:"text"`
+	Color       string `json:"color"`
+	TextColor   string `json:"textColor,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	Bold        bool   `json:"bold,omitempty"`
+	Padding     int    `json:"padding,omitempty"`
+	Radius      int    `json:"radius,omitempty"`
+	Anchor      *Point `json:"anchor,omitempty"`
+	Tail        string `json:"tail,omitempty"`
+	StrokeWidth int    `json:"strokeWidth,omitempty"`
+}
+
+// MagnifyPayload enlarges the SrcX/SrcY/SrcW/SrcH region of the image by
+// Zoom (default 2) and draws it with its top-left corner at (X, Y), inside a
+// circle (default) or rect Shape. Filter "nearest" keeps pixels hard-edged for
+// inspecting 1px details; the default is Catmull-Rom resampling.
+type MagnifyPayload struct {
+	SrcX        int     `json:"srcX"`
+	SrcY        int     `json:"srcY"`
+	SrcW        int     `json:"srcW"`
+	SrcH        int     `json:"srcH"`
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	Zoom        float64 `json:"zoom,omitempty"`
+	Shape       string  `json:"shape,omitempty"`
+	Filter      string  `json:"filter,omitempty"`
+	Color       string  `json:"color"`
+	StrokeWidth int     `json:"strokeWidth,omitempty"`
+	Connector   bool    `json:"connector,omitempty"`
+}
+
+// Region is an area of the image used by region effects. Shape is "rect"
+// (the default) or "ellipse" inscribed in the X/Y/W/H box.
+type Region struct {
 	X     int    `json:"x"`
 	Y     int    `json:"y"`
-	Text  string `json:"text"`
-	Color string `json:"color"`
-	Size  int    `json:"size"`
+	W     int    `json:"w"`
+	H     int    `json:"h"`
+	Shape string `json:"shape,omitempty"`
+}
+
+// SpotlightPayload keeps Regions untouched and dims everything else towards
+// Color (default black) by Dim (0-1, default 0.6). The outside can also be
+// desaturated and blurred with Blur as the radius.
+type SpotlightPayload struct {
+	Regions    []Region `json:"regions"`
+	Color      string   `json:"color,omitempty"`
+	Dim        *float64 `json:"dim,omitempty"`
+	Desaturate bool     `json:"desaturate,omitempty"`
+	Blur       int      `json:"blur,omitempty"`
+}
+
+// ImagePayload composites a PNG or JPEG stamp with its unrotated top-left
+// corner at (X, Y). The image comes from Path, or from the export request's
+// Assets when Ref is set. Rotation is in degrees clockwise around the stamp
+// centre; Scale defaults to 1 and Opacity to fully opaque.
+type ImagePayload struct {
+	X        int      `json:"x"`
+	Y        int      `json:"y"`
+	Path     string   `json:"path,omitempty"`
+	Ref      string   `json:"ref,omitempty"`
+	Scale    float64  `json:"scale,omitempty"`
+	Rotation float64  `json:"rotation,omitempty"`
+	Opacity  *float64 `json:"opacity,omitempty"`
 }
 
+// RedactPayload irreversibly hides the X/Y/W/H box. Mode "solid" (default)
+// fills it with an opaque Color (default black); "noise" fills Size-pixel
+// blocks (default 8) with colors derived from Seed and the block position
+// only. Redactions always render before every other op.
+type RedactPayload struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	W     int    `json:"w"`
+	H     int    `json:"h"`
+	Mode  string `json:"mode,omitempty"`
+	Color string `json:"color,omitempty"`
+	Size  int    `json:"size,omitempty"`
+	Seed  int64  `json:"seed,omitempty"`
+}
+
+// EffectMask limits blur, pixelate and the adjustments to a shape. Shape
+// "ellipse" is inscribed in the effect's X/Y/W/H box; "polygon" and "path" (a
+// freehand lasso, smoothed like a pen stroke) use Points instead, and their
+// bounding box replaces the effect's box. Feather softens the edge over that
+// many pixels.
+type EffectMask struct {
+	Shape   string  `json:"shape"`
+	Points  []Point `json:"points,omitempty"`
+	Feather int     `json:"feather,omitempty"`
+}
+
+// BlurPayload blurs the X/Y/W/H box, or only the part inside Mask.
 type BlurPayload struct {
-	X      int `json:"x"`
-	Y      int `json:"y"`
-	W      int `json:"w"`
-	H      int `json:"h"`
-	Radius int `json:"radius"`
+	X      int         `json:"x"`
+	Y      int         `json:"y"`
+	W      int         `json:"w"`
+	H      int         `json:"h"`
+	Radius int         `json:"radius"`
+	Mask   *EffectMask `json:"mask,omitempty"`
 }
 
+// PixelatePayload pixelates the X/Y/W/H box, or only the part inside Mask.
 type PixelatePayload struct {
-	X    int `json:"x"`
-	Y    int `json:"y"`
-	W    int `json:"w"`
-	H    int `json:"h"`
-	Size int `json:"size"`
+	X    int         `json:"x"`
+	Y    int         `json:"y"`
+	W    int         `json:"w"`
+	H    int         `json:"h"`
+	Size int         `json:"size"`
+	Mask *EffectMask `json:"mask,omitempty"`
+}
+
+// AdjustPayload recolors the X/Y/W/H box, or only the part inside Mask, with
+// the CSS filter function of the same name as the op kind. Amount defaults to
+// 1: full effect for grayscale and invert (0-1), no change for brightness,
+// contrast and saturate (0 or more, 1 is unchanged).
+type AdjustPayload struct {
+	X      int         `json:"x"`
+	Y      int         `json:"y"`
+	W      int         `json:"w"`
+	H      int         `json:"h"`
+	Amount *float64    `json:"amount,omitempty"`
+	Mask   *EffectMask `json:"mask,omitempty"`
+}
+
+// MeasurePayload is a dimension line from (X1, Y1) to (X2, Y2) with end
+// ticks and a label centred on it. The label is the distance in image pixels,
+// or in logical points when Unit is "pt" (pixels divided by the render's
+// display scale); Label replaces the text entirely. Size is the label's font
+// size (default 12).
+type MeasurePayload struct {
+	X1          int    `json:"x1"`
+	Y1          int    `json:"y1"`
+	X2          int    `json:"x2"`
+	Y2          int    `json:"y2"`
+	Color       string `json:"color"`
+	TextColor   string `json:"textColor,omitempty"`
+	StrokeWidth int    `json:"strokeWidth,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Size        int    `json:"size,omitempty"`
+}
+
+// KeysPayload draws a keyboard shortcut such as "Ctrl+Shift+P" as keycaps
+// joined by plus signs, with its top-left corner at (X, Y). A plus key is
+// written as "++", e.g. "Ctrl++". Theme is "light" (the default) or "dark";
+// Size is the label font size (default 14).
+type KeysPayload struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Keys  string `json:"keys"`
+	Theme string `json:"theme,omitempty"`
+	Size  int    `json:"size,omitempty"`
+}
+
+// CursorPayload draws a mouse pointer with its hotspot at (X, Y). Shape is
+// "arrow" (the default) or "ibeam". Cursors are drawn at the capture's display
+// scale, times Scale (default 1).
+type CursorPayload struct {
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+	Shape string  `json:"shape,omitempty"`
+	Scale float64 `json:"scale,omitempty"`
 }
 
+// ClickPayload marks a click at (X, Y) with a dot and fading rings out to
+// Radius (default 16).
+type ClickPayload struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Color  string `json:"color"`
+	Radius int    `json:"radius,omitempty"`
+}
+
+const maxTextSize = 512
+
+const maxStrokeWidth = 256
+
+// maxStepSize bounds a step badge's diameter; its label font grows with it.
+const maxStepSize = 1024
+
+const maxHeadSize = 512
+
+// minDash is the shortest non-zero dash or gap, in pixels.
+const minDash = 0.5
+
+const maxZoom = 16
+
+const maxFeather = 64
+
+const maxAdjustAmount = 10
+
+const maxCursorScale = 8
+
+const maxClickRadius = 256
+
+// maxRegionSide bounds the sides of effect regions and of boxes shaped by an
+// ellipse mask; nothing larger than the biggest canvas can show on it, and
+// ellipses are flattened from the full box.
+const maxRegionSide = maxCanvasSide
+
+var (
+	knownTails          = map[string]struct{}{"": {}, "bubble": {}, "leader": {}, "none": {}}
+	knownMagnifyShapes  = map[string]struct{}{"": {}, "circle": {}, "rect": {}}
+	knownMagnifyFilters = map[string]struct{}{"": {}, "smooth": {}, "nearest": {}}
+	knownRegionShapes   = map[string]struct{}{"": {}, "rect": {}, "ellipse": {}}
+	knownRedactModes    = map[string]struct{}{"": {}, "solid": {}, "noise": {}}
+	knownFillRules      = map[string]struct{}{"": {}, "nonzero": {}, "evenodd": {}}
+	knownArrowHeads     = map[string]struct{}{"": {}, "open": {}, "triangle": {}, "double": {}, "dot": {}, "none": {}}
+	knownMaskShapes     = map[string]struct{}{"ellipse": {}, "polygon": {}, "path": {}}
+	knownMeasureUnits   = map[string]struct{}{"": {}, "px": {}, "pt": {}}
+	knownKeyThemes      = map[string]struct{}{"": {}, "light": {}, "dark": {}}
+	knownCursorShapes   = map[string]struct{}{"": {}, "arrow": {}, "ibeam": {}}
+)
+
 var knownKinds = map[string]struct{}{
-	"rect":     {},
-	"line":     {},
-	"arrow":    {},
-	"text":     {},
-	"blur":     {},
-	"pixelate": {},
+	"rect":      {},
+	"ellipse":   {},
+	"line":      {},
+	"arrow":     {},
+	"curve":     {},
+	"pen":       {},
+	"polygon":   {},
+	"text":      {},
+	"highlight": {},
+	"step":      {},
+	"callout":   {},
+	"magnify":   {},
+	"spotlight": {},
+	"image":     {},
+	"redact":    {},
+	"blur":      {},
+	"pixelate":  {},
+	"measure":   {},
+	"keys":      {},
+	"cursor":    {},
+	"click":     {},
+	// Region adjustments, see AdjustPayload.
+	"grayscale":  {},
+	"invert":     {},
+	"brightness": {},
+	"contrast":   {},
+	"saturate":   {},
 }
 
 func ValidateOps(ops []core.AnnotationOp) error {
@@ -74,13 +410,29 @@ func ValidateOps(ops []core.AnnotationOp) error {
 		if _, ok := knownKinds[op.Kind]; !ok {
 			return &core.AppError{Code: core.ErrInvalidOpKind, Message: "unsupported op kind: " + op.Kind}
 		}
+		op, err := validateAutoColor(op)
+		if err != nil {
+			return err
+		}
 		if err := validatePayload(op); err != nil {
 			return err
 		}
+		if err := validateTransform(op); err != nil {
+			return err
+		}
+		if err := validateDecorations(op); err != nil {
+			return err
+		}
+		if err := validateCompositing(op); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// SortOps orders ops for rendering by Z, then ID, and numbers any step ops
+// that did not set one explicitly. Redactions keep their place, so they hide
+// whatever was drawn below them, as in the editor preview.
 func SortOps(ops []core.AnnotationOp) {
 	sort.SliceStable(ops, func(i, j int) bool {
 		if ops[i].Z == ops[j].Z {
@@ -88,6 +440,32 @@ func SortOps(ops []core.AnnotationOp) {
 		}
 		return ops[i].Z < ops[j].Z
 	})
+	numberSteps(ops)
+}
+
+// numberSteps assigns each unnumbered step the number after the previous
+// step in sorted order, so explicit numbers restart the sequence. Payloads
+// that fail to decode are left for ValidateOps/ApplyOps to report.
+func numberSteps(ops []core.AnnotationOp) {
+	last := 0
+	for i, op := range ops {
+		if op.Kind != "step" {
+			continue
+		}
+		var p StepPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			continue
+		}
+		if p.Number == 0 {
+			p.Number = last + 1
+			b, err := json.Marshal(p)
+			if err != nil {
+				continue
+			}
+			ops[i].Payload = b
+		}
+		last = p.Number
+	}
 }
 
 func validatePayload(op core.AnnotationOp) error {
@@ -100,31 +478,364 @@ func validatePayload(op core.AnnotationOp) error {
 		if err := json.Unmarshal(op.Payload, &p); err != nil {
 			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
 		}
+		if err := validateStroke(op.ID, p.StrokeWidth, p.Dash, p.Cap, p.Join); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
+	case "ellipse":
+		var p EllipsePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.W < 0 || p.H < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "ellipse has negative size: " + op.ID}
+		}
+		if err := validateStrokeWidth(op.ID, p.StrokeWidth); err != nil {
+			return err
+		}
+		return validateColors(p.Color, p.FillColor)
 	case "line":
 		var p LinePayload
 		if err := json.Unmarshal(op.Payload, &p); err != nil {
 			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
 		}
+		if err := validateStroke(op.ID, p.StrokeWidth, p.Dash, p.Cap, p.Join); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
 	case "arrow":
 		var p ArrowPayload
 		if err := json.Unmarshal(op.Payload, &p); err != nil {
 			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
 		}
+		if p.C2 != nil && p.C1 == nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "arrow c2 requires c1: " + op.ID}
+		}
+		if _, ok := knownArrowHeads[p.Head]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported arrow head: " + p.Head}
+		}
+		if p.HeadSize < 0 || p.HeadSize > maxHeadSize {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "arrow head size out of range: " + op.ID}
+		}
+		if err := validateStroke(op.ID, p.StrokeWidth, p.Dash, p.Cap, p.Join); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
+	case "curve":
+		var p CurvePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.C1 == nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "curve op needs control point c1: " + op.ID}
+		}
+		if err := validateStroke(op.ID, p.StrokeWidth, p.Dash, p.Cap, p.Join); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
+	case "pen":
+		var p PenPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if len(p.Points) == 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "pen op has no points: " + op.ID}
+		}
+		if p.Tolerance < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "pen op has negative tolerance: " + op.ID}
+		}
+		if err := validateStrokeWidth(op.ID, p.StrokeWidth); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
+	case "polygon":
+		var p PolygonPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if len(p.Points) < 2 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "polygon op needs at least two points: " + op.ID}
+		}
+		if _, ok := knownFillRules[p.FillRule]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported fill rule: " + p.FillRule}
+		}
+		if err := validateStroke(op.ID, p.StrokeWidth, p.Dash, p.Cap, p.Join); err != nil {
+			return err
+		}
+		return validateColors(p.Color, p.FillColor)
 	case "text":
 		var p TextPayload
 		if err := json.Unmarshal(op.Payload, &p); err != nil {
 			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
 		}
+		if p.Size < 0 || p.Size > maxTextSize {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "text size out of range: " + op.ID}
+		}
+		return validateColors(p.Color, p.Background)
+	case "highlight":
+		var p HighlightPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if len(p.Points) == 0 && (p.W < 0 || p.H < 0) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "highlight has negative size: " + op.ID}
+		}
+		if err := validateStrokeWidth(op.ID, p.StrokeWidth); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
+	case "step":
+		var p StepPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.Number < 0 || p.Size < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "step number and size must not be negative: " + op.ID}
+		}
+		if p.Size > maxStepSize {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "step size out of range: " + op.ID}
+		}
+		return validateColors(p.Color, p.TextColor)
+	case "callout":
+		var p CalloutPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.Size < 0 || p.Size > maxTextSize {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "callout text size out of range: " + op.ID}
+		}
+		if _, ok := knownTails[p.Tail]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported callout tail: " + p.Tail}
+		}
+		if err := validateStrokeWidth(op.ID, p.StrokeWidth); err != nil {
+			return err
+		}
+		return validateColors(p.Color, p.TextColor)
+	case "magnify":
+		var p MagnifyPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.SrcW <= 0 || p.SrcH <= 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "magnify source area is empty: " + op.ID}
+		}
+		if p.Zoom < 0 || p.Zoom > maxZoom || math.IsNaN(p.Zoom) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "magnify zoom out of range: " + op.ID}
+		}
+		if _, ok := knownMagnifyShapes[p.Shape]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported magnify shape: " + p.Shape}
+		}
+		if _, ok := knownMagnifyFilters[p.Filter]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported magnify filter: " + p.Filter}
+		}
+		if err := validateStrokeWidth(op.ID, p.StrokeWidth); err != nil {
+			return err
+		}
+		return validateColors(p.Color)
+	case "spotlight":
+		var p SpotlightPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if len(p.Regions) == 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "spotlight has no regions: " + op.ID}
+		}
+		for _, r := range p.Regions {
+			if err := validateRegion(op.ID, r); err != nil {
+				return err
+			}
+		}
+		if p.Dim != nil && (*p.Dim < 0 || *p.Dim > 1) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "spotlight dim must be between 0 and 1: " + op.ID}
+		}
+		if p.Blur < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "spotlight blur must not be negative: " + op.ID}
+		}
+		return validateColors(p.Color)
+	case "image":
+		var p ImagePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if (p.Path == "") == (p.Ref == "") {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "image op needs exactly one of path or ref: " + op.ID}
+		}
+		if p.Scale < 0 || p.Scale > maxStampScale || math.IsNaN(p.Scale) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "image scale out of range: " + op.ID}
+		}
+		if math.IsNaN(p.Rotation) || math.IsInf(p.Rotation, 0) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "invalid image rotation: " + op.ID}
+		}
+		if p.Opacity != nil && (*p.Opacity < 0 || *p.Opacity > 1) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "image opacity must be between 0 and 1: " + op.ID}
+		}
+	case "redact":
+		var p RedactPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.W < 0 || p.H < 0 || p.Size < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "redaction size must not be negative: " + op.ID}
+		}
+		if _, ok := knownRedactModes[p.Mode]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported redaction mode: " + p.Mode}
+		}
+		_, err := newRedactFill(p)
+		return err
 	case "blur":
 		var p BlurPayload
 		if err := json.Unmarshal(op.Payload, &p); err != nil {
 			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
 		}
+		return validateMask(op.ID, p.Mask, p.W, p.H)
 	case "pixelate":
 		var p PixelatePayload
 		if err := json.Unmarshal(op.Payload, &p); err != nil {
 			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
 		}
+		return validateMask(op.ID, p.Mask, p.W, p.H)
+	case "measure":
+		var p MeasurePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if _, ok := knownMeasureUnits[p.Unit]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported measure unit: " + p.Unit}
+		}
+		if p.Size < 0 || p.Size > maxTextSize {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "measure label size out of range: " + op.ID}
+		}
+		if err := validateStrokeWidth(op.ID, p.StrokeWidth); err != nil {
+			return err
+		}
+		return validateColors(p.Color, p.TextColor)
+	case "keys":
+		var p KeysPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if len(splitKeys(p.Keys)) == 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "keys op has no keys: " + op.ID}
+		}
+		if _, ok := knownKeyThemes[p.Theme]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported keys theme: " + p.Theme}
+		}
+		if p.Size < 0 || p.Size > maxTextSize {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "keys size out of range: " + op.ID}
+		}
+		return nil
+	case "cursor":
+		var p CursorPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if _, ok := knownCursorShapes[p.Shape]; !ok {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported cursor shape: " + p.Shape}
+		}
+		if !(p.Scale >= 0 && p.Scale <= maxCursorScale) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "cursor scale out of range: " + op.ID}
+		}
+		return nil
+	case "click":
+		var p ClickPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.Radius < 0 || p.Radius > maxClickRadius {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "click radius out of range: " + op.ID}
+		}
+		return validateColors(p.Color)
+	case "grayscale", "invert", "brightness", "contrast", "saturate":
+		var p AdjustPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		if p.W < 0 || p.H < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: op.Kind + " has negative size: " + op.ID}
+		}
+		if p.Amount != nil {
+			limit := float64(maxAdjustAmount)
+			if op.Kind == "grayscale" || op.Kind == "invert" {
+				limit = 1
+			}
+			if !(*p.Amount >= 0 && *p.Amount <= limit) {
+				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: op.Kind + " amount out of range: " + op.ID}
+			}
+		}
+		return validateMask(op.ID, p.Mask, p.W, p.H)
+	}
+	return nil
+}
+
+func validateStroke(id string, width int, dash []float64, lineCap, lineJoin string) error {
+	if err := validateStrokeWidth(id, width); err != nil {
+		return err
+	}
+	for _, d := range dash {
+		// Shorter dashes than minDash would split a path into millions of
+		// invisible pieces; zero stays allowed for dotted round caps.
+		if d < 0 || (d > 0 && d < minDash) || math.IsNaN(d) || math.IsInf(d, 0) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "invalid dash length in op: " + id}
+		}
+	}
+	if _, ok := knownCaps[lineCap]; !ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported line cap: " + lineCap}
+	}
+	if _, ok := knownJoins[lineJoin]; !ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported line join: " + lineJoin}
+	}
+	return nil
+}
+
+func validateRegion(id string, r Region) error {
+	if r.W < 0 || r.H < 0 {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "region has negative size in op: " + id}
+	}
+	if r.W > maxRegionSide || r.H > maxRegionSide {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "region size out of range in op: " + id}
+	}
+	if _, ok := knownRegionShapes[r.Shape]; !ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported region shape: " + r.Shape}
+	}
+	return nil
+}
+
+// validateMask checks m against the w by h box of the effect it shapes.
+func validateMask(id string, m *EffectMask, w, h int) error {
+	if m == nil {
+		return nil
+	}
+	if _, ok := knownMaskShapes[m.Shape]; !ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "unsupported mask shape: " + m.Shape}
+	}
+	if m.Shape == "ellipse" && (w > maxRegionSide || h > maxRegionSide) {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "ellipse mask size out of range: " + id}
+	}
+	if m.Shape != "ellipse" && len(m.Points) < 3 {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "mask needs at least three points: " + id}
+	}
+	if m.Feather < 0 || m.Feather > maxFeather {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "mask feather out of range: " + id}
+	}
+	return nil
+}
+
+func validateStrokeWidth(id string, width int) error {
+	if width < 0 || width > maxStrokeWidth {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "stroke width out of range: " + id}
+	}
+	return nil
+}
+
+// validateColors checks optional color fields; empty strings are allowed.
+func validateColors(colors ...string) error {
+	for _, c := range colors {
+		if c == "" {
+			continue
+		}
+		if _, err := parseColor(c); err != nil {
+			return err
+		}
 	}
 	return nil
 }
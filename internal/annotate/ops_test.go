@@ -22,8 +22,307 @@ func TestValidateOpsAcceptsKnownKinds(t *testing.T) {
 		{ID: "4", Kind: "text", Payload: json.RawMessage(`{"x":1,"y":2,"text":"abc","color":"#ff0000","size":12}`)},
 		{ID: "5", Kind: "blur", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"radius":2}`)},
 		{ID: "6", Kind: "pixelate", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"size":8}`)},
+		{ID: "7", Kind: "ellipse", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"color":"#ff0000","strokeWidth":2,"fill":true}`)},
+		{ID: "8", Kind: "pen", Payload: json.RawMessage(`{"points":[{"x":1,"y":2},{"x":3,"y":4}],"color":"#ff0000","strokeWidth":3,"smooth":true}`)},
+		{ID: "9", Kind: "highlight", Payload: json.RawMessage(`{"points":[{"x":1,"y":2},{"x":30,"y":2}],"color":"#ffeb3b","strokeWidth":18}`)},
+		{ID: "10", Kind: "step", Payload: json.RawMessage(`{"x":10,"y":10,"number":2,"size":28,"color":"#ff3b30","textColor":"#ffffff"}`)},
+		{ID: "11", Kind: "callout", Payload: json.RawMessage(`{"x":10,"y":10,"text":"Save","color":"#1f2937","anchor":{"x":80,"y":90},"tail":"leader"}`)},
+		{ID: "12", Kind: "magnify", Payload: json.RawMessage(`{"srcX":1,"srcY":2,"srcW":10,"srcH":10,"x":40,"y":40,"zoom":3,"shape":"circle","color":"#111827","connector":true}`)},
+		{ID: "13", Kind: "spotlight", Payload: json.RawMessage(`{"regions":[{"x":1,"y":2,"w":3,"h":4},{"x":5,"y":6,"w":7,"h":8,"shape":"ellipse"}],"dim":0.7,"blur":3}`)},
+		{ID: "14", Kind: "image", Payload: json.RawMessage(`{"x":1,"y":2,"ref":"logo","scale":0.5,"rotation":-15,"opacity":0.8}`)},
+		{ID: "15", Kind: "redact", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"mode":"noise","size":6,"seed":7}`)},
+		{ID: "16", Kind: "polygon", Payload: json.RawMessage(`{"points":[{"x":1,"y":2},{"x":30,"y":2},{"x":15,"y":20}],"color":"#ff0000","fill":true,"fillRule":"evenodd"}`)},
+		{ID: "17", Kind: "curve", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":30,"y2":4,"c1":{"x":15,"y":-20},"c2":{"x":20,"y":20},"color":"#ff0000"}`)},
+		{ID: "18", Kind: "arrow", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":30,"y2":4,"c1":{"x":15,"y":-20},"head":"double","headSize":10}`)},
+		{ID: "24", Kind: "measure", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":30,"y2":2,"color":"#ff0000","unit":"pt","size":14}`)},
+		{ID: "25", Kind: "keys", Payload: json.RawMessage(`{"x":1,"y":2,"keys":"Ctrl+Shift+P","theme":"dark","size":16}`)},
+		{ID: "26", Kind: "cursor", Payload: json.RawMessage(`{"x":1,"y":2,"shape":"ibeam","scale":1.5}`)},
+		{ID: "27", Kind: "click", Payload: json.RawMessage(`{"x":1,"y":2,"color":"#ff0000","radius":20}`)},
+		{ID: "19", Kind: "grayscale", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4}`)},
+		{ID: "20", Kind: "invert", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"amount":0.5}`)},
+		{ID: "21", Kind: "brightness", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"amount":0.4}`)},
+		{ID: "22", Kind: "contrast", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"amount":2}`)},
+		{ID: "23", Kind: "saturate", Payload: json.RawMessage(`{"amount":0,"mask":{"shape":"polygon","points":[{"x":1,"y":2},{"x":30,"y":2},{"x":15,"y":20}]}}`)},
 	}
 	if err := ValidateOps(ops); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 }
+
+func TestValidateOpsRejectsUnknownStrokeStyle(t *testing.T) {
+	for _, payload := range []string{
+		`{"x1":1,"y1":2,"x2":3,"y2":4,"color":"#ff0000","cap":"arrow"}`,
+		`{"x1":1,"y1":2,"x2":3,"y2":4,"color":"#ff0000","join":"sharp"}`,
+		`{"x1":1,"y1":2,"x2":3,"y2":4,"color":"#ff0000","dash":[4,-2]}`,
+	} {
+		op := core.AnnotationOp{ID: "1", Kind: "line", Payload: json.RawMessage(payload)}
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for payload %s", payload)
+		}
+	}
+}
+
+func TestValidateOpsRejectsInvalidColor(t *testing.T) {
+	op := core.AnnotationOp{ID: "1", Kind: "rect", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"color":"#ff00f"}`)}
+	if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+		t.Fatal("expected error for invalid color")
+	}
+}
+
+func TestSortOpsNumbersStepsInDrawOrder(t *testing.T) {
+	ops := []core.AnnotationOp{
+		{ID: "c", Kind: "step", Z: 3, Payload: json.RawMessage(`{"x":1,"y":1}`)},
+		{ID: "a", Kind: "step", Z: 1, Payload: json.RawMessage(`{"x":1,"y":1}`)},
+		{ID: "b", Kind: "rect", Z: 2, Payload: json.RawMessage(`{"x":1,"y":1,"w":2,"h":2}`)},
+		{ID: "e", Kind: "step", Z: 3, Payload: json.RawMessage(`{"x":1,"y":1,"number":7}`)},
+		{ID: "d", Kind: "step", Z: 3, Payload: json.RawMessage(`{"x":1,"y":1}`)},
+	}
+	SortOps(ops)
+	want := map[string]int{"a": 1, "c": 2, "d": 3, "e": 7}
+	for _, op := range ops {
+		if op.Kind != "step" {
+			continue
+		}
+		var p StepPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			t.Fatalf("decode %s: %v", op.ID, err)
+		}
+		if p.Number != want[op.ID] {
+			t.Fatalf("expected step %s to be %d, got %d", op.ID, want[op.ID], p.Number)
+		}
+	}
+}
+
+func TestValidateOpsRejectsAmbiguousImageSource(t *testing.T) {
+	op := core.AnnotationOp{ID: "1", Kind: "image", Payload: json.RawMessage(`{"x":1,"y":2,"path":"a.png","ref":"logo"}`)}
+	if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+		t.Fatal("expected error when both path and ref are set")
+	}
+}
+
+func TestSortOpsKeepsRedactionsInZOrder(t *testing.T) {
+	ops := []core.AnnotationOp{
+		{ID: "a", Kind: "rect", Z: 2},
+		{ID: "b", Kind: "redact", Z: 5},
+		{ID: "c", Kind: "redact", Z: 1},
+	}
+	SortOps(ops)
+	if ops[0].ID != "c" || ops[1].ID != "a" || ops[2].ID != "b" {
+		t.Fatalf("unexpected order %s,%s,%s", ops[0].ID, ops[1].ID, ops[2].ID)
+	}
+}
+
+func TestValidateOpsChecksTransforms(t *testing.T) {
+	rect := json.RawMessage(`{"x":1,"y":2,"w":3,"h":4}`)
+	zero := 0.0
+	valid := core.AnnotationOp{ID: "1", Kind: "rect", Payload: rect, Transform: &core.Transform{Rotate: 30, SkewX: 10, PivotX: 2, PivotY: 4}}
+	if err := ValidateOps([]core.AnnotationOp{valid}); err != nil {
+		t.Fatalf("expected valid transform, got %v", err)
+	}
+	for name, op := range map[string]core.AnnotationOp{
+		"zero scale":      {ID: "1", Kind: "rect", Payload: rect, Transform: &core.Transform{ScaleX: &zero}},
+		"collapsing skew": {ID: "1", Kind: "rect", Payload: rect, Transform: &core.Transform{SkewX: 45, SkewY: 45}},
+		"right angle":     {ID: "1", Kind: "rect", Payload: rect, Transform: &core.Transform{SkewY: 90}},
+		"image effect":    {ID: "1", Kind: "blur", Payload: rect, Transform: &core.Transform{Rotate: 10}},
+	} {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s", name)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadPolygons(t *testing.T) {
+	for _, payload := range []string{
+		`{"points":[{"x":1,"y":2}],"color":"#ff0000"}`,
+		`{"points":[{"x":1,"y":2},{"x":3,"y":4},{"x":5,"y":0}],"fill":true,"fillRule":"winding"}`,
+	} {
+		op := core.AnnotationOp{ID: "1", Kind: "polygon", Payload: json.RawMessage(payload)}
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for payload %s", payload)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadCurves(t *testing.T) {
+	for _, op := range []core.AnnotationOp{
+		{ID: "1", Kind: "curve", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":30,"y2":4}`)},
+		{ID: "2", Kind: "arrow", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":30,"y2":4,"c2":{"x":5,"y":5}}`)},
+		{ID: "3", Kind: "arrow", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":30,"y2":4,"head":"diamond"}`)},
+	} {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for op %s", op.ID)
+		}
+	}
+}
+
+func TestValidateOpsChecksShadowAndOutline(t *testing.T) {
+	rect := json.RawMessage(`{"x":1,"y":2,"w":3,"h":4}`)
+	valid := core.AnnotationOp{ID: "1", Kind: "text", Payload: json.RawMessage(`{"x":1,"y":2,"text":"a"}`), Shadow: &core.Shadow{X: 2, Y: 2, Blur: 3}, Outline: &core.Outline{}}
+	if err := ValidateOps([]core.AnnotationOp{valid}); err != nil {
+		t.Fatalf("expected valid decorations, got %v", err)
+	}
+	for name, op := range map[string]core.AnnotationOp{
+		"negative blur":  {ID: "1", Kind: "rect", Payload: rect, Shadow: &core.Shadow{Blur: -1}},
+		"bad color":      {ID: "1", Kind: "rect", Payload: rect, Shadow: &core.Shadow{Color: "#12"}},
+		"wide outline":   {ID: "1", Kind: "rect", Payload: rect, Outline: &core.Outline{Width: 99}},
+		"effect outline": {ID: "1", Kind: "pixelate", Payload: rect, Outline: &core.Outline{}},
+	} {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s", name)
+		}
+	}
+}
+
+func TestValidateOpsChecksOpacityAndBlend(t *testing.T) {
+	rect := json.RawMessage(`{"x":1,"y":2,"w":3,"h":4}`)
+	half, over := 0.5, 1.5
+	if err := ValidateOps([]core.AnnotationOp{{ID: "1", Kind: "blur", Payload: rect, Opacity: &half, Blend: "screen"}}); err != nil {
+		t.Fatalf("expected valid compositing, got %v", err)
+	}
+	for name, op := range map[string]core.AnnotationOp{
+		"opacity range": {ID: "1", Kind: "rect", Payload: rect, Opacity: &over},
+		"unknown blend": {ID: "1", Kind: "rect", Payload: rect, Blend: "burn"},
+		"redact":        {ID: "1", Kind: "redact", Payload: rect, Opacity: &half},
+	} {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s", name)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadMasks(t *testing.T) {
+	for _, payload := range []string{
+		`{"x":1,"y":2,"w":30,"h":40,"mask":{"shape":"star"}}`,
+		`{"mask":{"shape":"polygon","points":[{"x":1,"y":2},{"x":3,"y":4}]}}`,
+		`{"x":1,"y":2,"w":30,"h":40,"mask":{"shape":"ellipse","feather":500}}`,
+	} {
+		op := core.AnnotationOp{ID: "1", Kind: "blur", Payload: json.RawMessage(payload)}
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for payload %s", payload)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadAdjustments(t *testing.T) {
+	cases := map[string]string{
+		"grayscale":  `{"x":1,"y":2,"w":3,"h":4,"amount":1.5}`,
+		"invert":     `{"x":1,"y":2,"w":3,"h":4,"amount":-0.1}`,
+		"brightness": `{"x":1,"y":2,"w":3,"h":4,"amount":50}`,
+		"contrast":   `{"x":1,"y":2,"w":-3,"h":4}`,
+		"saturate":   `{"x":1,"y":2,"w":3,"h":4,"mask":{"shape":"star"}}`,
+	}
+	for kind, payload := range cases {
+		op := core.AnnotationOp{ID: "1", Kind: kind, Payload: json.RawMessage(payload)}
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s payload %s", kind, payload)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadMeasures(t *testing.T) {
+	for _, payload := range []string{
+		`{"x1":1,"y1":2,"x2":30,"y2":2,"color":"#ff0000","unit":"cm"}`,
+		`{"x1":1,"y1":2,"x2":30,"y2":2,"color":"#ff0000","size":1000}`,
+		`{"x1":1,"y1":2,"x2":30,"y2":2,"color":"#ff0000","textColor":"#12"}`,
+	} {
+		op := core.AnnotationOp{ID: "1", Kind: "measure", Payload: json.RawMessage(payload)}
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for payload %s", payload)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadKeys(t *testing.T) {
+	for _, payload := range []string{
+		`{"x":1,"y":2,"keys":""}`,
+		`{"x":1,"y":2,"keys":" "}`,
+		`{"x":1,"y":2,"keys":"Ctrl+C","theme":"neon"}`,
+		`{"x":1,"y":2,"keys":"Ctrl+C","size":-1}`,
+	} {
+		op := core.AnnotationOp{ID: "1", Kind: "keys", Payload: json.RawMessage(payload)}
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for payload %s", payload)
+		}
+	}
+}
+
+func TestValidateOpsRejectsBadPointerOps(t *testing.T) {
+	cases := []core.AnnotationOp{
+		{ID: "1", Kind: "cursor", Payload: json.RawMessage(`{"x":1,"y":2,"shape":"hand"}`)},
+		{ID: "2", Kind: "cursor", Payload: json.RawMessage(`{"x":1,"y":2,"scale":-1}`)},
+		{ID: "3", Kind: "cursor", Payload: json.RawMessage(`{"x":1,"y":2,"scale":100}`)},
+		{ID: "4", Kind: "click", Payload: json.RawMessage(`{"x":1,"y":2,"color":"#ff0000","radius":-4}`)},
+		{ID: "5", Kind: "click", Payload: json.RawMessage(`{"x":1,"y":2,"color":"#ff00f"}`)},
+	}
+	for _, op := range cases {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s payload %s", op.Kind, op.Payload)
+		}
+	}
+}
+
+func TestValidateOpsAutoColor(t *testing.T) {
+	ok := []core.AnnotationOp{
+		{ID: "1", Kind: "rect", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"color":"auto"}`)},
+		{ID: "2", Kind: "callout", Payload: json.RawMessage(`{"x":1,"y":2,"text":"hi","color":"auto","textColor":"#ffffff"}`)},
+	}
+	if err := ValidateOps(ok); err != nil {
+		t.Fatalf("expected auto colors to validate, got %v", err)
+	}
+	bad := []core.AnnotationOp{
+		{ID: "1", Kind: "redact", Payload: json.RawMessage(`{"x":1,"y":2,"w":3,"h":4,"color":"auto"}`)},
+		{ID: "2", Kind: "spotlight", Payload: json.RawMessage(`{"regions":[{"x":1,"y":2,"w":3,"h":4}],"color":"auto"}`)},
+		{ID: "3", Kind: "text", Payload: json.RawMessage(`{"x":1,"y":2,"text":"hi","color":"#ffffff","background":"auto"}`)},
+	}
+	for _, op := range bad {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s payload %s", op.Kind, op.Payload)
+		}
+	}
+	for _, palette := range [][]string{{"#ff0000", "auto"}, {"#ff0000", "#ff00f"}} {
+		if err := ValidatePalette(palette); err == nil {
+			t.Fatalf("expected error for palette %v", palette)
+		}
+	}
+}
+
+func TestValidateOpsBoundsStrokeGeometry(t *testing.T) {
+	cases := []core.AnnotationOp{
+		{ID: "1", Kind: "line", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":300,"y2":4,"color":"#ff0000","dash":[1e-7,1e-7]}`)},
+		{ID: "2", Kind: "pen", Payload: json.RawMessage(`{"points":[{"x":1,"y":2},{"x":30,"y":4}],"color":"#ff0000","strokeWidth":2000000000}`)},
+		{ID: "3", Kind: "ellipse", Payload: json.RawMessage(`{"x":1,"y":2,"w":30,"h":40,"color":"#ff0000","strokeWidth":2000000000}`)},
+		{ID: "4", Kind: "highlight", Payload: json.RawMessage(`{"points":[{"x":1,"y":2},{"x":30,"y":4}],"color":"#ff0000","strokeWidth":2000000000}`)},
+		{ID: "5", Kind: "rect", Payload: json.RawMessage(`{"x":1,"y":2,"w":30,"h":40,"color":"#ff0000","strokeWidth":-1}`)},
+		{ID: "6", Kind: "arrow", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":300,"y2":4,"color":"#ff0000","head":"dot","headSize":2000000000}`)},
+	}
+	for _, op := range cases {
+		if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+			t.Fatalf("expected error for %s payload %s", op.Kind, op.Payload)
+		}
+	}
+	dotted := core.AnnotationOp{ID: "1", Kind: "line", Payload: json.RawMessage(`{"x1":1,"y1":2,"x2":300,"y2":4,"color":"#ff0000","cap":"round","dash":[0,6]}`)}
+	if err := ValidateOps([]core.AnnotationOp{dotted}); err != nil {
+		t.Fatalf("expected zero-length dashes to stay valid, got %v", err)
+	}
+}
+
+func TestValidateOpsRejectsOversizedStep(t *testing.T) {
+	op := core.AnnotationOp{ID: "1", Kind: "step", Payload: json.RawMessage(`{"x":1,"y":2,"color":"#ff0000","size":100000000}`)}
+	if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+		t.Fatal("expected error for oversized step badge")
+	}
+}
+
+func TestValidateOpsRejectsOversizedRegion(t *testing.T) {
+	op := core.AnnotationOp{ID: "1", Kind: "spotlight", Payload: json.RawMessage(`{"regions":[{"x":0,"y":0,"w":400000000,"h":400000000,"shape":"ellipse"}]}`)}
+	if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+		t.Fatal("expected error for oversized spotlight region")
+	}
+}
+
+func TestValidateOpsRejectsOversizedEllipseMask(t *testing.T) {
+	op := core.AnnotationOp{ID: "1", Kind: "blur", Payload: json.RawMessage(`{"x":0,"y":0,"w":400000000,"h":400000000,"mask":{"shape":"ellipse"}}`)}
+	if err := ValidateOps([]core.AnnotationOp{op}); err == nil {
+		t.Fatal("expected error for oversized ellipse mask")
+	}
+}
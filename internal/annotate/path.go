@@ -0,0 +1,190 @@
+package annotate
+
+import "math"
+
+// fpoint is a sub-pixel position used by path geometry. Payloads stay in
+// integer pixels; fpoint only exists between parsing and rasterization.
+type fpoint struct {
+	x, y float64
+}
+
+func (p fpoint) add(q fpoint) fpoint    { return fpoint{p.x + q.x, p.y + q.y} }
+func (p fpoint) sub(q fpoint) fpoint    { return fpoint{p.x - q.x, p.y - q.y} }
+func (p fpoint) scale(k float64) fpoint { return fpoint{p.x * k, p.y * k} }
+
+func unit(p fpoint) fpoint {
+	l := math.Hypot(p.x, p.y)
+	if l == 0 {
+		return fpoint{}
+	}
+	return fpoint{p.x / l, p.y / l}
+}
+
+func perp(p fpoint) fpoint { return fpoint{-p.y, p.x} }
+
+// toFloatPoints converts payload points to path coordinates. Like the canvas
+// preview, integer coordinates fall on pixel edges, not pixel centres.
+func toFloatPoints(pts []Point) []fpoint {
+	out := make([]fpoint, len(pts))
+	for i, p := range pts {
+		out[i] = fpoint{float64(p.X), float64(p.Y)}
+	}
+	return out
+}
+
+func rectPoints(x, y, w, h int) []fpoint {
+	x0, y0, x1, y1 := float64(x), float64(y), float64(x+w), float64(y+h)
+	return []fpoint{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+}
+
+// maxEllipseSegments bounds how finely an ellipse or rounded corner is
+// flattened, so a huge radius can't allocate without limit. It keeps edges
+// about two pixels long up to a radius of roughly 1300 pixels, beyond any
+// canvas corner that matters.
+const maxEllipseSegments = 4096
+
+// ellipsePoints approximates an ellipse with a polygon whose edges are about
+// two pixels long, starting at angle zero and running clockwise on screen.
+func ellipsePoints(c fpoint, rx, ry float64) []fpoint {
+	perimeter := math.Pi * (3*(rx+ry) - math.Sqrt((3*rx+ry)*(rx+3*ry)))
+	n := max(16, int(math.Min(math.Ceil(perimeter/8), maxEllipseSegments/4))*4)
+	pts := make([]fpoint, n)
+	for i := range pts {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		pts[i] = fpoint{c.x + rx*math.Cos(a), c.y + ry*math.Sin(a)}
+	}
+	return pts
+}
+
+// roundedRectPoints outlines r with quarter-circle corners of the given
+// radius, clamped so opposite corners never overlap.
+func roundedRectPoints(x0, y0, x1, y1, radius float64) []fpoint {
+	radius = math.Max(0, math.Min(radius, math.Min(x1-x0, y1-y0)/2))
+	if radius == 0 {
+		return []fpoint{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+	}
+	steps := max(2, int(math.Min(math.Ceil(radius/2), maxEllipseSegments/4)))
+	corners := []struct {
+		c     fpoint
+		start float64
+	}{
+		{fpoint{x1 - radius, y0 + radius}, -math.Pi / 2},
+		{fpoint{x1 - radius, y1 - radius}, 0},
+		{fpoint{x0 + radius, y1 - radius}, math.Pi / 2},
+		{fpoint{x0 + radius, y0 + radius}, math.Pi},
+	}
+	pts := make([]fpoint, 0, 4*(steps+1))
+	for _, k := range corners {
+		for i := 0; i <= steps; i++ {
+			a := k.start + math.Pi/2*float64(i)/float64(steps)
+			pts = append(pts, fpoint{k.c.x + radius*math.Cos(a), k.c.y + radius*math.Sin(a)})
+		}
+	}
+	return pts
+}
+
+// boxExit returns where the ray from a box's centre towards target leaves a
+// box with the given half extents, and whether target lies outside the box.
+func boxExit(center, target fpoint, halfW, halfH float64) (fpoint, bool) {
+	d := target.sub(center)
+	t := math.Inf(1)
+	if d.x != 0 {
+		t = math.Min(t, halfW/math.Abs(d.x))
+	}
+	if d.y != 0 {
+		t = math.Min(t, halfH/math.Abs(d.y))
+	}
+	if t >= 1 {
+		return target, false
+	}
+	return center.add(d.scale(t)), true
+}
+
+// ellipseExit returns where the ray from an ellipse's centre towards target
+// crosses the ellipse.
+func ellipseExit(center, target fpoint, rx, ry float64) fpoint {
+	d := target.sub(center)
+	k := math.Hypot(d.x/rx, d.y/ry)
+	if k == 0 {
+		return center
+	}
+	return center.add(d.scale(1 / k))
+}
+
+func discPoints(c fpoint, r float64) []fpoint {
+	return ellipsePoints(c, r, r)
+}
+
+func distToSegment(p, a, b fpoint) float64 {
+	dx := b.x - a.x
+	dy := b.y - a.y
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return math.Hypot(p.x-a.x, p.y-a.y)
+	}
+	t := ((p.x-a.x)*dx + (p.y-a.y)*dy) / l2
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.x-(a.x+t*dx), p.y-(a.y+t*dy))
+}
+
+// simplifyRDP drops points that deviate less than epsilon from the chord
+// between their neighbours (Ramer–Douglas–Peucker). Endpoints are kept.
+func simplifyRDP(pts []fpoint, epsilon float64) []fpoint {
+	if len(pts) < 3 || epsilon <= 0 {
+		return pts
+	}
+	keep := make([]bool, len(pts))
+	keep[0] = true
+	keep[len(pts)-1] = true
+	type span struct{ first, last int }
+	stack := []span{{0, len(pts) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDist := 0.0
+		index := -1
+		for i := s.first + 1; i < s.last; i++ {
+			if d := distToSegment(pts[i], pts[s.first], pts[s.last]); d > maxDist {
+				maxDist = d
+				index = i
+			}
+		}
+		if index >= 0 && maxDist > epsilon {
+			keep[index] = true
+			stack = append(stack, span{s.first, index}, span{index, s.last})
+		}
+	}
+	out := make([]fpoint, 0, len(pts))
+	for i, k := range keep {
+		if k {
+			out = append(out, pts[i])
+		}
+	}
+	return out
+}
+
+// catmullRom interpolates a uniform Catmull-Rom spline through pts, emitting
+// steps samples per segment. The spline passes through every input point, so
+// smoothing never moves the ends of a stroke.
+func catmullRom(pts []fpoint, steps int) []fpoint {
+	if len(pts) < 3 || steps < 2 {
+		return pts
+	}
+	out := make([]fpoint, 0, (len(pts)-1)*steps+1)
+	for i := 0; i < len(pts)-1; i++ {
+		p0 := pts[max(i-1, 0)]
+		p1 := pts[i]
+		p2 := pts[i+1]
+		p3 := pts[min(i+2, len(pts)-1)]
+		for s := 0; s < steps; s++ {
+			t := float64(s) / float64(steps)
+			t2 := t * t
+			t3 := t2 * t
+			out = append(out, fpoint{
+				x: 0.5 * (2*p1.x + (-p0.x+p2.x)*t + (2*p0.x-5*p1.x+4*p2.x-p3.x)*t2 + (-p0.x+3*p1.x-3*p2.x+p3.x)*t3),
+				y: 0.5 * (2*p1.y + (-p0.y+p2.y)*t + (2*p0.y-5*p1.y+4*p2.y-p3.y)*t2 + (-p0.y+3*p1.y-3*p2.y+p3.y)*t3),
+			})
+		}
+	}
+	return append(out, pts[len(pts)-1])
+}
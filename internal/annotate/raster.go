@@ -0,0 +1,143 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+type fillRule int
+
+const (
+	fillNonZero fillRule = iota
+	fillEvenOdd
+)
+
+func (r fillRule) inside(winding int) bool {
+	if r == fillEvenOdd {
+		return winding%2 != 0
+	}
+	return winding != 0
+}
+
+// subSamples is the number of scanlines sampled per pixel row. Horizontal
+// coverage is computed exactly, so this only bounds vertical AA precision.
+const subSamples = 16
+
+type edge struct {
+	x0, y0, x1, y1 float64
+	dir            int
+}
+
+type crossing struct {
+	x   float64
+	dir int
+}
+
+// rasterize returns the anti-aliased coverage of the closed polygons, clipped
+// to clip, or nil when nothing is covered. Polygons are implicitly closed.
+func rasterize(polys [][]fpoint, rule fillRule, clip image.Rectangle) *image.Alpha {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	var edges []edge
+	for _, poly := range polys {
+		for i, a := range poly {
+			b := poly[(i+1)%len(poly)]
+			minX, maxX = math.Min(minX, a.x), math.Max(maxX, a.x)
+			minY, maxY = math.Min(minY, a.y), math.Max(maxY, a.y)
+			if a.y == b.y {
+				continue
+			}
+			e := edge{x0: a.x, y0: a.y, x1: b.x, y1: b.y, dir: 1}
+			if a.y > b.y {
+				e = edge{x0: b.x, y0: b.y, x1: a.x, y1: a.y, dir: -1}
+			}
+			edges = append(edges, e)
+		}
+	}
+	if len(edges) == 0 {
+		return nil
+	}
+	area := image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY)),
+	).Intersect(clip)
+	if area.Empty() {
+		return nil
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].y0 < edges[j].y0 })
+
+	mask := image.NewAlpha(area)
+	w := area.Dx()
+	acc := make([]float64, w+1)
+	left := float64(area.Min.X)
+	addSpan := func(x0, x1 float64) {
+		a := math.Max(0, math.Min(float64(w), x0-left))
+		b := math.Max(0, math.Min(float64(w), x1-left))
+		if b <= a {
+			return
+		}
+		ia, ib := int(a), int(b)
+		if ia == ib {
+			acc[ia] += b - a
+			return
+		}
+		acc[ia] += float64(ia+1) - a
+		for i := ia + 1; i < ib; i++ {
+			acc[i]++
+		}
+		acc[ib] += b - float64(ib)
+	}
+
+	var active []int
+	var xs []crossing
+	next := 0
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		clear(acc)
+		for k := 0; k < subSamples; k++ {
+			sy := float64(y) + (float64(k)+0.5)/subSamples
+			for next < len(edges) && edges[next].y0 <= sy {
+				active = append(active, next)
+				next++
+			}
+			xs = xs[:0]
+			kept := active[:0]
+			for _, i := range active {
+				e := edges[i]
+				if e.y1 <= sy {
+					continue
+				}
+				kept = append(kept, i)
+				xs = append(xs, crossing{x: e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0), dir: e.dir})
+			}
+			active = kept
+			sort.Slice(xs, func(i, j int) bool { return xs[i].x < xs[j].x })
+			winding := 0
+			for i := 0; i+1 < len(xs); i++ {
+				winding += xs[i].dir
+				if rule.inside(winding) {
+					addSpan(xs[i].x, xs[i+1].x)
+				}
+			}
+		}
+		row := mask.Pix[(y-area.Min.Y)*mask.Stride:]
+		for x := 0; x < w; x++ {
+			row[x] = uint8(math.Min(1, acc[x]/subSamples)*255 + 0.5)
+		}
+	}
+	return mask
+}
+
+// paintMask composites c onto dst through an anti-aliased coverage mask.
+func paintMask(dst draw.Image, mask *image.Alpha, c color.Color) {
+	if mask == nil {
+		return
+	}
+	draw.DrawMask(dst, mask.Rect, image.NewUniform(c), image.Point{}, mask, mask.Rect.Min, draw.Over)
+}
+
+func fillPolygons(dst draw.Image, polys [][]fpoint, rule fillRule, c color.Color) {
+	paintMask(dst, rasterize(polys, rule, dst.Bounds()), c)
+}
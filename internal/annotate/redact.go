@@ -0,0 +1,158 @@
+package annotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+const defaultRedactBlock = 8
+
+// redactFill returns the pixel a redaction writes at (x, y). It depends only
+// on the payload and the position, never on the pixels being hidden, so the
+// result carries no information about the original content.
+type redactFill func(x, y int) color.RGBA
+
+func newRedactFill(p RedactPayload) (redactFill, error) {
+	if p.Mode == "noise" {
+		size := p.Size
+		if size <= 0 {
+			size = defaultRedactBlock
+		}
+		seed := uint64(p.Seed)
+		if seed == 0 {
+			seed = uint64(p.X)<<48 ^ uint64(p.Y)<<32 ^ uint64(p.W)<<16 ^ uint64(p.H)
+		}
+		return func(x, y int) color.RGBA {
+			// Blocks are aligned to the redaction's own origin.
+			h := splitmix64(seed ^ uint64(floorDiv(x-p.X, size))<<32 ^ uint64(uint32(floorDiv(y-p.Y, size))))
+			return color.RGBA{R: 64 + uint8(h&0x7f), G: 64 + uint8(h>>8&0x7f), B: 64 + uint8(h>>16&0x7f), A: 255}
+		}, nil
+	}
+	if p.Color == "" {
+		p.Color = "#000000"
+	}
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return nil, err
+	}
+	if c.A != 255 {
+		return nil, &core.AppError{Code: core.ErrInvalidOpPayload, Message: "redaction color must be opaque"}
+	}
+	solid := color.RGBA{R: c.R, G: c.G, B: c.B, A: 255}
+	return func(int, int) color.RGBA { return solid }, nil
+}
+
+func applyRedact(dst draw.Image, p RedactPayload) error {
+	fill, err := newRedactFill(p)
+	if err != nil {
+		return err
+	}
+	r := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H).Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dst.Set(x, y, fill(x, y))
+		}
+	}
+	return nil
+}
+
+// ApplyRedactedOps renders ops in order like ApplyOpsWithOptions and checks
+// each redaction against original as soon as it is drawn, so ops below it in
+// Z order end up hidden too. Ops above it may draw over the redacted area and
+// are not mistaken for leaked pixels. It returns the redacted areas.
+func ApplyRedactedOps(canvas *image.RGBA, original image.Image, ops []core.AnnotationOp, opts RenderOptions) ([]core.Rect, error) {
+	var areas []core.Rect
+	for i, op := range ops {
+		if err := ApplyOpsWithOptions(canvas, ops[i:i+1], opts); err != nil {
+			return nil, err
+		}
+		if op.Kind != "redact" {
+			continue
+		}
+		area, err := VerifyRedactions(original, canvas, ops[i:i+1])
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, area...)
+	}
+	return areas, nil
+}
+
+// VerifyRedactions checks that no pixel inside a redacted area of canvas
+// still holds its value from original, except where the redaction's own fill
+// happens to match it. It returns the redacted areas, clipped to the canvas,
+// in base image coordinates.
+func VerifyRedactions(original image.Image, canvas *image.RGBA, redactions []core.AnnotationOp) ([]core.Rect, error) {
+	payloads := make([]RedactPayload, len(redactions))
+	rects := make([]image.Rectangle, len(redactions))
+	for i, op := range redactions {
+		if err := json.Unmarshal(op.Payload, &payloads[i]); err != nil {
+			return nil, &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		p := payloads[i]
+		rects[i] = image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H).Intersect(canvas.Rect)
+	}
+	var areas []core.Rect
+	for i, r := range rects {
+		if r.Empty() {
+			continue
+		}
+		areas = append(areas, core.Rect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()})
+		fill, err := newRedactFill(payloads[i])
+		if err != nil {
+			return nil, err
+		}
+		leaked := 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				pt := image.Pt(x, y)
+				if !pt.In(original.Bounds()) || coveredByAny(rects[i+1:], pt) {
+					continue
+				}
+				got := canvas.RGBAAt(x, y)
+				orig := color.RGBAModel.Convert(original.At(x, y)).(color.RGBA)
+				if got == orig && got != fill(x, y) {
+					leaked++
+				}
+			}
+		}
+		if leaked > 0 {
+			return nil, &core.AppError{
+				Code:    core.ErrRedactionFailed,
+				Message: fmt.Sprintf("redaction %s left %d original pixels", redactions[i].ID, leaked),
+			}
+		}
+	}
+	return areas, nil
+}
+
+// coveredByAny reports whether a later redaction overwrote pt; that
+// redaction's own check is responsible for the pixel.
+func coveredByAny(later []image.Rectangle, pt image.Point) bool {
+	for _, r := range later {
+		if pt.In(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -8,237 +8,479 @@ import (
 	"math"
 	"strconv"
 
+	xdraw "golang.org/x/image/draw"
+
 	"github.com/mohamoundaljadan/screenshot/internal/core"
 )
 
+// RenderOptions carries export-level inputs that some ops depend on.
+type RenderOptions struct {
+	// Assets holds embedded images that image ops reference by ID.
+	Assets map[string][]byte
+	// Scale is the capture's display scale factor (core.DisplayInfo.Scale),
+	// used to label measurements in points. Zero is treated as 1.
+	Scale int
+	// Palette lists the colors an op with color "auto" picks from. Empty
+	// means a built-in set of annotation colors.
+	Palette []string
+}
+
 func ApplyOps(dst draw.Image, ops []core.AnnotationOp) error {
+	return ApplyOpsWithOptions(dst, ops, RenderOptions{})
+}
+
+func ApplyOpsWithOptions(dst draw.Image, ops []core.AnnotationOp, opts RenderOptions) error {
 	for _, op := range ops {
-		switch op.Kind {
-		case "rect":
-			var p RectPayload
-			if err := json.Unmarshal(op.Payload, &p); err != nil {
-				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
-			}
-			renderRect(dst, p)
-		case "line":
-			var p LinePayload
-			if err := json.Unmarshal(op.Payload, &p); err != nil {
-				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
-			}
-			renderLine(dst, p)
-		case "arrow":
-			var p ArrowPayload
-			if err := json.Unmarshal(op.Payload, &p); err != nil {
-				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
-			}
-			renderArrow(dst, p)
-		case "text":
-			var p TextPayload
-			if err := json.Unmarshal(op.Payload, &p); err != nil {
-				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
-			}
-			renderText(dst, p)
-		case "blur":
-			var p BlurPayload
-			if err := json.Unmarshal(op.Payload, &p); err != nil {
-				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
-			}
-			applyBlur(dst, p)
-		case "pixelate":
-			var p PixelatePayload
-			if err := json.Unmarshal(op.Payload, &p); err != nil {
-				return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
-			}
-			applyPixelate(dst, p)
+		op, err := resolveAutoColor(dst, op, opts)
+		if err != nil {
+			return err
+		}
+		switch {
+		case needsLayer(op):
+			err = renderLayered(dst, op, opts)
+		case op.Transform != nil:
+			err = renderTransformed(dst, op, opts)
+		default:
+			err = renderOp(dst, op, opts)
+		}
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func parseColor(hex string) color.RGBA {
-	if len(hex) != 7 || hex[0] != '#' {
-		return color.RGBA{R: 255, G: 0, B: 0, A: 255}
+func renderOp(dst draw.Image, op core.AnnotationOp, opts RenderOptions) error {
+	var err error
+	switch op.Kind {
+	case "rect":
+		var p RectPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderRect(dst, p)
+	case "ellipse":
+		var p EllipsePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderEllipse(dst, p)
+	case "line":
+		var p LinePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderLine(dst, p)
+	case "arrow":
+		var p ArrowPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderArrow(dst, p)
+	case "curve":
+		var p CurvePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderCurve(dst, p)
+	case "pen":
+		var p PenPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderPen(dst, p)
+	case "polygon":
+		var p PolygonPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderPolygon(dst, p)
+	case "text":
+		var p TextPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderText(dst, p)
+	case "highlight":
+		var p HighlightPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderHighlight(dst, p)
+	case "step":
+		var p StepPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderStep(dst, p)
+	case "callout":
+		var p CalloutPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderCallout(dst, p)
+	case "magnify":
+		var p MagnifyPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderMagnify(dst, p)
+	case "spotlight":
+		var p SpotlightPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = applySpotlight(dst, p)
+	case "image":
+		var p ImagePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderImage(dst, p, opts)
+	case "redact":
+		var p RedactPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = applyRedact(dst, p)
+	case "blur":
+		var p BlurPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = applyBlur(dst, p)
+	case "pixelate":
+		var p PixelatePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = applyPixelate(dst, p)
+	case "measure":
+		var p MeasurePayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderMeasure(dst, p, opts.Scale)
+	case "keys":
+		var p KeysPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderKeys(dst, p)
+	case "cursor":
+		var p CursorPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderCursor(dst, p, opts.Scale)
+	case "click":
+		var p ClickPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = renderClick(dst, p)
+	case "grayscale", "invert", "brightness", "contrast", "saturate":
+		var p AdjustPayload
+		if err := json.Unmarshal(op.Payload, &p); err != nil {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: err.Error()}
+		}
+		err = applyAdjust(dst, op.Kind, p)
 	}
-	r, err := strconv.ParseUint(hex[1:3], 16, 8)
+	return err
+}
+
+func renderRect(dst draw.Image, p RectPayload) error {
+	c, err := parseColor(p.Color)
 	if err != nil {
-		return color.RGBA{R: 255, G: 0, B: 0, A: 255}
+		return err
+	}
+	if p.Fill {
+		draw.Draw(dst, image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H), image.NewUniform(c), image.Point{}, draw.Over)
+		return nil
 	}
-	g, err := strconv.ParseUint(hex[3:5], 16, 8)
+	strokePath(dst, rectPoints(p.X, p.Y, p.W, p.H), true, newStrokeStyle(p.StrokeWidth, p.Cap, p.Join, p.Dash), c)
+	return nil
+}
+
+// renderEllipse draws the ellipse inscribed in the payload box. The stroke is
+// centred on the outline, matching CanvasRenderingContext2D.ellipse + stroke.
+func renderEllipse(dst draw.Image, p EllipsePayload) error {
+	c, err := parseColor(p.Color)
 	if err != nil {
-		return color.RGBA{R: 255, G: 0, B: 0, A: 255}
+		return err
 	}
-	b, err := strconv.ParseUint(hex[5:7], 16, 8)
+	fill := c
+	if p.FillColor != "" {
+		if fill, err = parseColor(p.FillColor); err != nil {
+			return err
+		}
+	}
+	if p.W <= 0 || p.H <= 0 {
+		return nil
+	}
+	pts := ellipsePoints(fpoint{float64(p.X) + float64(p.W)/2, float64(p.Y) + float64(p.H)/2}, float64(p.W)/2, float64(p.H)/2)
+	if p.Fill {
+		fillPolygons(dst, [][]fpoint{pts}, fillNonZero, fill)
+	}
+	strokePath(dst, pts, true, newStrokeStyle(p.StrokeWidth, "", joinRound, nil), c)
+	return nil
+}
+
+func renderLine(dst draw.Image, p LinePayload) error {
+	c, err := parseColor(p.Color)
 	if err != nil {
-		return color.RGBA{R: 255, G: 0, B: 0, A: 255}
+		return err
 	}
-	return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}
+	pts := []fpoint{{float64(p.X1), float64(p.Y1)}, {float64(p.X2), float64(p.Y2)}}
+	strokePath(dst, pts, false, newStrokeStyle(p.StrokeWidth, p.Cap, p.Join, p.Dash), c)
+	return nil
 }
 
-func renderRect(dst draw.Image, p RectPayload) {
-	c := parseColor(p.Color)
-	r := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H)
-	if p.Fill {
-		draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Over)
-		return
+func renderArrow(dst draw.Image, p ArrowPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
 	}
-	s := p.StrokeWidth
-	if s <= 0 {
-		s = 2
+	head := float64(p.HeadSize)
+	if head <= 0 {
+		head = 14
+	}
+	shaft := p.path()
+	dir, ok := headDirection(shaft, head)
+	if !ok {
+		return nil
 	}
-	for i := 0; i < s; i++ {
-		drawLine(dst, p.X+i, p.Y+i, p.X+p.W-i, p.Y+i, c)
-		drawLine(dst, p.X+i, p.Y+p.H-i, p.X+p.W-i, p.Y+p.H-i, c)
-		drawLine(dst, p.X+i, p.Y+i, p.X+i, p.Y+p.H-i, c)
-		drawLine(dst, p.X+p.W-i, p.Y+i, p.X+p.W-i, p.Y+p.H-i, c)
+	start, end := shaft[0], shaft[len(shaft)-1]
+	startDir, _ := headDirection(reversed(shaft), head)
+	// Filled heads cover the shaft end, so pull it back from the tip to keep a
+	// wide butt cap from poking out past the point.
+	switch p.Head {
+	case "triangle":
+		shaft = trimEnd(shaft, head/2)
+	case "double":
+		shaft = reversed(trimEnd(reversed(trimEnd(shaft, head/2)), head/2))
+	}
+	strokePath(dst, shaft, false, newStrokeStyle(p.StrokeWidth, p.Cap, p.Join, p.Dash), c)
+
+	// Heads are always solid so a dashed shaft still ends in a clear point.
+	switch p.Head {
+	case "", "open":
+		w1, w2 := headWings(end, dir, head)
+		strokePath(dst, []fpoint{w1, end, w2}, false, newStrokeStyle(p.StrokeWidth, p.Cap, p.Join, nil), c)
+	case "triangle":
+		w1, w2 := headWings(end, dir, head)
+		fillPolygons(dst, [][]fpoint{{w1, end, w2}}, fillNonZero, c)
+	case "double":
+		w1, w2 := headWings(end, dir, head)
+		s1, s2 := headWings(start, startDir, head)
+		fillPolygons(dst, [][]fpoint{{w1, end, w2}, {s1, start, s2}}, fillNonZero, c)
+	case "dot":
+		r := math.Max(head/3, float64(p.StrokeWidth))
+		fillPolygons(dst, [][]fpoint{discPoints(end, r)}, fillNonZero, c)
 	}
+	return nil
 }
 
-func renderLine(dst draw.Image, p LinePayload) {
-	c := parseColor(p.Color)
+func renderPen(dst draw.Image, p PenPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
 	s := p.StrokeWidth
 	if s <= 0 {
-		s = 2
+		s = 3
 	}
-	for i := -s / 2; i <= s/2; i++ {
-		drawLine(dst, p.X1+i, p.Y1, p.X2+i, p.Y2, c)
-		drawLine(dst, p.X1, p.Y1+i, p.X2, p.Y2+i, c)
+	pts := toFloatPoints(p.Points)
+	if p.Smooth {
+		tol := p.Tolerance
+		if tol == 0 {
+			tol = 1.5
+		}
+		pts = catmullRom(simplifyRDP(pts, tol), 8)
 	}
+	strokePath(dst, pts, false, newStrokeStyle(s, capRound, joinRound, nil), c)
+	return nil
 }
 
-func renderArrow(dst draw.Image, p ArrowPayload) {
-	renderLine(dst, p.LinePayload)
-	c := parseColor(p.Color)
-	head := p.HeadSize
-	if head <= 0 {
-		head = 14
+func renderPolygon(dst draw.Image, p PolygonPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	fill := c
+	if p.FillColor != "" {
+		if fill, err = parseColor(p.FillColor); err != nil {
+			return err
+		}
 	}
-	angle := math.Atan2(float64(p.Y2-p.Y1), float64(p.X2-p.X1))
-	a1 := angle + math.Pi*0.82
-	a2 := angle - math.Pi*0.82
-	x3 := p.X2 + int(float64(head)*math.Cos(a1))
-	y3 := p.Y2 + int(float64(head)*math.Sin(a1))
-	x4 := p.X2 + int(float64(head)*math.Cos(a2))
-	y4 := p.Y2 + int(float64(head)*math.Sin(a2))
-	drawLine(dst, p.X2, p.Y2, x3, y3, c)
-	drawLine(dst, p.X2, p.Y2, x4, y4, c)
+	pts := toFloatPoints(p.Points)
+	if p.Fill {
+		rule := fillNonZero
+		if p.FillRule == "evenodd" {
+			rule = fillEvenOdd
+		}
+		fillPolygons(dst, [][]fpoint{pts}, rule, fill)
+	}
+	strokePath(dst, pts, !p.Open, newStrokeStyle(p.StrokeWidth, p.Cap, p.Join, p.Dash), c)
+	return nil
 }
 
-func renderText(dst draw.Image, p TextPayload) {
-	c := parseColor(p.Color)
+func renderHighlight(dst draw.Image, p HighlightPayload) error {
+	if p.Color == "" {
+		p.Color = "#ffeb3b"
+	}
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	var polys [][]fpoint
+	if len(p.Points) > 0 {
+		s := p.StrokeWidth
+		if s <= 0 {
+			s = 18
+		}
+		polys = newStrokeStyle(s, capButt, joinRound, nil).outline(toFloatPoints(p.Points), false)
+	} else if p.W > 0 && p.H > 0 {
+		polys = [][]fpoint{rectPoints(p.X, p.Y, p.W, p.H)}
+	}
+	blendMask(dst, rasterize(polys, fillNonZero, dst.Bounds()), c, blendMultiply)
+	return nil
+}
+
+func renderStep(dst draw.Image, p StepPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	if p.TextColor == "" {
+		p.TextColor = "#ffffff"
+	}
+	tc, err := parseColor(p.TextColor)
+	if err != nil {
+		return err
+	}
 	size := p.Size
 	if size <= 0 {
-		size = 2
+		size = 28
 	}
-	x := p.X
-	for _, r := range p.Text {
-		renderGlyph(dst, x, p.Y, r, c, size)
-		x += 6 * size
+	center := fpoint{float64(p.X), float64(p.Y)}
+	fillPolygons(dst, [][]fpoint{discPoints(center, float64(size)/2)}, fillNonZero, c)
+
+	number := p.Number
+	if number <= 0 {
+		number = 1
 	}
+	label := strconv.Itoa(number)
+	// Shrink multi-digit labels so they stay inside the badge.
+	b, err := newTextBlock(label, max(1, size*11/20-(len(label)-1)*size/10), true)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	b.drawCentered(dst, center, tc)
+	return nil
 }
 
-func applyBlur(dst draw.Image, p BlurPayload) {
-	if p.Radius <= 0 {
-		p.Radius = 2
+func renderMagnify(dst draw.Image, p MagnifyPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
 	}
-	bounds := dst.Bounds()
-	r := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H).Intersect(bounds)
-	src := image.NewRGBA(bounds)
-	draw.Draw(src, bounds, dst, bounds.Min, draw.Src)
-	for y := r.Min.Y; y < r.Max.Y; y++ {
-		for x := r.Min.X; x < r.Max.X; x++ {
-			var rs, gs, bs, count int
-			for yy := y - p.Radius; yy <= y+p.Radius; yy++ {
-				for xx := x - p.Radius; xx <= x+p.Radius; xx++ {
-					if !image.Pt(xx, yy).In(bounds) {
-						continue
-					}
-					cr, cg, cb, _ := src.At(xx, yy).RGBA()
-					rs += int(cr >> 8)
-					gs += int(cg >> 8)
-					bs += int(cb >> 8)
-					count++
-				}
-			}
-			if count > 0 {
-				dst.Set(x, y, color.RGBA{R: uint8(rs / count), G: uint8(gs / count), B: uint8(bs / count), A: 255})
-			}
-		}
-	}
-}
-
-func applyPixelate(dst draw.Image, p PixelatePayload) {
-	if p.Size <= 1 {
-		p.Size = 8
+	zoom := p.Zoom
+	if zoom == 0 {
+		zoom = 2
 	}
-	bounds := dst.Bounds()
-	r := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H).Intersect(bounds)
-	for y := r.Min.Y; y < r.Max.Y; y += p.Size {
-		for x := r.Min.X; x < r.Max.X; x += p.Size {
-			x2 := min(x+p.Size, r.Max.X)
-			y2 := min(y+p.Size, r.Max.Y)
-			cr, cg, cb, _ := dst.At(x, y).RGBA()
-			block := color.RGBA{R: uint8(cr >> 8), G: uint8(cg >> 8), B: uint8(cb >> 8), A: 255}
-			draw.Draw(dst, image.Rect(x, y, x2, y2), image.NewUniform(block), image.Point{}, draw.Src)
-		}
+	srcRect := image.Rect(p.SrcX, p.SrcY, p.SrcX+p.SrcW, p.SrcY+p.SrcH).Intersect(dst.Bounds())
+	if srcRect.Empty() {
+		return nil
 	}
-}
+	target := image.Rect(p.X, p.Y,
+		p.X+int(math.Round(float64(srcRect.Dx())*zoom)),
+		p.Y+int(math.Round(float64(srcRect.Dy())*zoom)))
+	if target.Empty() {
+		return nil
+	}
+	// Copy the source first: the lens may overlap the area it magnifies.
+	src := image.NewRGBA(srcRect)
+	draw.Draw(src, srcRect, dst, srcRect.Min, draw.Src)
+	// Only the part of the lens on the canvas is scaled; the scaler keeps
+	// mapping the whole target, so the crop doesn't shift the image.
+	scaled := image.NewRGBA(target.Intersect(dst.Bounds()))
+	var scaler xdraw.Scaler = xdraw.CatmullRom
+	if p.Filter == "nearest" {
+		scaler = xdraw.NearestNeighbor
+	}
+	scaler.Scale(scaled, target, src, srcRect, xdraw.Src, nil)
 
-func drawLine(img draw.Image, x0, y0, x1, y1 int, c color.RGBA) {
-	dx := abs(x1 - x0)
-	sx := -1
-	if x0 < x1 {
-		sx = 1
+	w := p.StrokeWidth
+	if w <= 0 {
+		w = 3
 	}
-	dy := -abs(y1 - y0)
-	sy := -1
-	if y0 < y1 {
-		sy = 1
+	tx0, ty0, tx1, ty1 := float64(target.Min.X), float64(target.Min.Y), float64(target.Max.X), float64(target.Max.Y)
+	tc := fpoint{(tx0 + tx1) / 2, (ty0 + ty1) / 2}
+	lens := rectPoints(target.Min.X, target.Min.Y, target.Dx(), target.Dy())
+	if p.Shape != "rect" {
+		lens = ellipsePoints(tc, (tx1-tx0)/2, (ty1-ty0)/2)
 	}
-	err := dx + dy
-	for {
-		if image.Pt(x0, y0).In(img.Bounds()) {
-			img.Set(x0, y0, c)
-		}
-		if x0 == x1 && y0 == y1 {
-			break
-		}
-		e2 := 2 * err
-		if e2 >= dy {
-			err += dy
-			x0 += sx
-		}
-		if e2 <= dx {
-			err += dx
-			y0 += sy
+	if p.Connector {
+		sc := fpoint{float64(srcRect.Min.X+srcRect.Max.X) / 2, float64(srcRect.Min.Y+srcRect.Max.Y) / 2}
+		from, _ := boxExit(sc, tc, float64(srcRect.Dx())/2, float64(srcRect.Dy())/2)
+		to, _ := boxExit(tc, sc, (tx1-tx0)/2, (ty1-ty0)/2)
+		if p.Shape != "rect" {
+			to = ellipseExit(tc, sc, (tx1-tx0)/2, (ty1-ty0)/2)
 		}
+		thin := newStrokeStyle(max(1, w/2), capButt, joinMiter, nil)
+		strokePath(dst, rectPoints(srcRect.Min.X, srcRect.Min.Y, srcRect.Dx(), srcRect.Dy()), true, thin, c)
+		strokePath(dst, []fpoint{from, to}, false, thin, c)
+	}
+	if mask := rasterize([][]fpoint{lens}, fillNonZero, dst.Bounds()); mask != nil {
+		draw.DrawMask(dst, mask.Rect, scaled, mask.Rect.Min, mask, mask.Rect.Min, draw.Over)
+	}
+	strokePath(dst, lens, true, newStrokeStyle(w, capButt, joinMiter, nil), c)
+	return nil
+}
+
+// applyBlur blurs the region with a Gaussian whose standard deviation is the
+// radius, matching CSS blur(). Premultiplied channels are blurred together so
+// transparent areas of the canvas don't bleed black into their neighbours.
+func applyBlur(dst draw.Image, p BlurPayload) error {
+	if p.Radius <= 0 {
+		p.Radius = 2
+	}
+	box := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H)
+	blur := func(img draw.Image, r image.Rectangle) { gaussianBlur(img, r, float64(p.Radius)) }
+	if p.Mask != nil {
+		return applyMasked(dst, box, p.Mask, 3*p.Radius+1, blur)
 	}
+	blur(dst, box)
+	return nil
 }
 
-func renderGlyph(dst draw.Image, x, y int, r rune, c color.RGBA, scale int) {
-	seed := int(r)
-	for gy := 0; gy < 7; gy++ {
-		for gx := 0; gx < 5; gx++ {
-			if ((seed >> ((gx + gy) % 8)) & 1) == 0 {
-				continue
-			}
-			for sy := 0; sy < scale; sy++ {
-				for sx := 0; sx < scale; sx++ {
-					px := x + gx*scale + sx
-					py := y + gy*scale + sy
-					if image.Pt(px, py).In(dst.Bounds()) {
-						dst.Set(px, py, c)
-					}
-				}
-			}
-		}
+func applyPixelate(dst draw.Image, p PixelatePayload) error {
+	if p.Size <= 1 {
+		p.Size = 8
+	}
+	box := image.Rect(p.X, p.Y, p.X+p.W, p.Y+p.H)
+	pixelate := func(img draw.Image, r image.Rectangle) { pixelateRect(img, r, p.Size) }
+	if p.Mask != nil {
+		return applyMasked(dst, box, p.Mask, 0, pixelate)
 	}
+	pixelate(dst, box)
+	return nil
 }
 
-func abs(n int) int {
-	if n < 0 {
-		return -n
+// pixelateRect fills size-pixel blocks of r, starting at its top-left corner,
+// with the color of each block's first pixel.
+func pixelateRect(dst draw.Image, r image.Rectangle, size int) {
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y += size {
+		for x := r.Min.X; x < r.Max.X; x += size {
+			x2 := min(x+size, r.Max.X)
+			y2 := min(y+size, r.Max.Y)
+			block := color.RGBAModel.Convert(dst.At(x, y))
+			draw.Draw(dst, image.Rect(x, y, x2, y2), image.NewUniform(block), image.Point{}, draw.Src)
+		}
 	}
-	return n
 }
 
 func min(a, b int) int {
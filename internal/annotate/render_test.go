@@ -0,0 +1,938 @@
+package annotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+func TestRenderEllipseStrokeLeavesInteriorUntouched(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	op := core.AnnotationOp{ID: "1", Kind: "ellipse", Payload: json.RawMessage(`{"x":10,"y":10,"w":40,"h":40,"color":"#00ff00","strokeWidth":4}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	green := color.RGBA{G: 255, A: 255}
+	for _, pt := range []image.Point{{30, 10}, {30, 49}, {10, 30}, {49, 30}} {
+		if got := img.RGBAAt(pt.X, pt.Y); got != green {
+			t.Fatalf("expected stroke at %v, got %v", pt, got)
+		}
+	}
+	if got := img.RGBAAt(30, 30); got.A != 0 {
+		t.Fatalf("expected untouched interior, got %v", got)
+	}
+	if got := img.RGBAAt(12, 12); got.A != 0 {
+		t.Fatalf("expected untouched corner of bounding box, got %v", got)
+	}
+}
+
+func TestRenderPenJoinsSegmentsWithoutGaps(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	op := core.AnnotationOp{ID: "1", Kind: "pen", Payload: json.RawMessage(`{"points":[{"x":5,"y":5},{"x":30,"y":30},{"x":55,"y":5}],"color":"#0000ff","strokeWidth":3}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	blue := color.RGBA{B: 255, A: 255}
+	for x := 5; x <= 55; x++ {
+		y := 5 + x - 5
+		if x > 30 {
+			y = 30 - (x - 30)
+		}
+		if got := img.RGBAAt(x, y); got != blue {
+			t.Fatalf("expected continuous stroke at (%d,%d), got %v", x, y, got)
+		}
+	}
+}
+
+func TestSimplifyRDPKeepsEndpointsAndCorners(t *testing.T) {
+	pts := []fpoint{{0, 0}, {1, 0.1}, {2, -0.1}, {3, 0}, {3, 5}}
+	got := simplifyRDP(pts, 0.5)
+	want := []fpoint{{0, 0}, {3, 0}, {3, 5}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestRenderTextDrawsGlyphsInsideMeasuredBounds(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	op := core.AnnotationOp{ID: "1", Kind: "text", Payload: json.RawMessage(`{"x":10,"y":30,"text":"Héllo\nwörld","color":"#ffffff","size":20,"bold":true}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	b, err := newTextBlock("Héllo\nwörld", 20, true)
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	defer b.close()
+	box := b.bounds(10, 30)
+	inked := map[int]bool{}
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if img.RGBAAt(x, y).A == 0 {
+				continue
+			}
+			if !image.Pt(x, y).In(box) {
+				t.Fatalf("ink at (%d,%d) outside measured bounds %v", x, y, box)
+			}
+			inked[y/b.lineHeight] = true
+		}
+	}
+	if len(inked) < 2 {
+		t.Fatalf("expected ink on two lines, got rows %v", inked)
+	}
+}
+
+func TestRenderTextBackgroundBox(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	op := core.AnnotationOp{ID: "1", Kind: "text", Payload: json.RawMessage(`{"x":20,"y":40,"text":"Hi","color":"#ffffff","size":16,"background":"#000000","padding":6}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	b, err := newTextBlock("Hi", 16, false)
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	defer b.close()
+	box := b.bounds(20, 40).Inset(-6)
+	if got := img.RGBAAt(box.Min.X, box.Min.Y); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected background at box corner, got %v", got)
+	}
+	if got := img.RGBAAt(box.Min.X-1, box.Min.Y); got.A != 0 {
+		t.Fatalf("expected nothing outside the box, got %v", got)
+	}
+}
+
+func TestRenderLineIsAntiAliased(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	op := core.AnnotationOp{ID: "1", Kind: "line", Payload: json.RawMessage(`{"x1":2,"y1":5,"x2":38,"y2":30,"color":"#ffffff","strokeWidth":3}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	var solid, partial int
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			switch a := img.RGBAAt(x, y).A; {
+			case a == 255:
+				solid++
+			case a > 0:
+				partial++
+			}
+		}
+	}
+	if solid == 0 || partial == 0 {
+		t.Fatalf("expected solid core and partially covered edges, got solid=%d partial=%d", solid, partial)
+	}
+}
+
+func TestRenderDashedLineLeavesGaps(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 10))
+	op := core.AnnotationOp{ID: "1", Kind: "line", Payload: json.RawMessage(`{"x1":0,"y1":5,"x2":60,"y2":5,"color":"#ffffff","strokeWidth":2,"dash":[10,5]}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	for x, want := range map[int]uint8{2: 255, 9: 255, 12: 0, 14: 0, 16: 255, 27: 0} {
+		if got := img.RGBAAt(x, 5).A; got != want {
+			t.Fatalf("expected alpha %d at x=%d, got %d", want, x, got)
+		}
+	}
+}
+
+func TestRenderRectStrokeIsCentredWithSquareCorners(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	op := core.AnnotationOp{ID: "1", Kind: "rect", Payload: json.RawMessage(`{"x":10,"y":10,"w":20,"h":20,"color":"#ffffff","strokeWidth":4}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	for _, pt := range []image.Point{{8, 8}, {11, 11}, {31, 31}, {20, 8}} {
+		if got := img.RGBAAt(pt.X, pt.Y).A; got != 255 {
+			t.Fatalf("expected stroke at %v, got alpha %d", pt, got)
+		}
+	}
+	for _, pt := range []image.Point{{7, 7}, {12, 12}, {32, 20}} {
+		if got := img.RGBAAt(pt.X, pt.Y).A; got != 0 {
+			t.Fatalf("expected no stroke at %v, got alpha %d", pt, got)
+		}
+	}
+}
+
+func TestRenderHighlightMultipliesWithBase(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 10; y++ {
+			img.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			if x >= 10 {
+				img.SetRGBA(x, y, color.RGBA{R: 20, G: 20, B: 20, A: 255})
+			}
+		}
+	}
+	op := core.AnnotationOp{ID: "1", Kind: "highlight", Payload: json.RawMessage(`{"x":0,"y":0,"w":20,"h":10,"color":"#ffff00"}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(5, 5); got != (color.RGBA{R: 255, G: 255, A: 255}) {
+		t.Fatalf("expected yellow over white, got %v", got)
+	}
+	if got := img.RGBAAt(15, 5); got != (color.RGBA{R: 20, G: 20, A: 255}) {
+		t.Fatalf("expected dark pixels to stay dark, got %v", got)
+	}
+}
+
+func TestRenderCalloutTailReachesAnchor(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 120))
+	op := core.AnnotationOp{ID: "1", Kind: "callout", Payload: json.RawMessage(`{"x":10,"y":10,"text":"Save","color":"#000000","anchor":{"x":150,"y":100}}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(12, 20); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected callout background inside box, got %v", got)
+	}
+	if got := img.RGBAAt(147, 98); got.A == 0 {
+		t.Fatalf("expected tail near anchor, got %v", got)
+	}
+	if got := img.RGBAAt(150, 20); got.A != 0 {
+		t.Fatalf("expected nothing away from callout, got %v", got)
+	}
+}
+
+func TestRenderMagnifyNearestCopiesSourcePixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 40))
+	img.SetRGBA(2, 3, color.RGBA{R: 255, A: 255})
+	op := core.AnnotationOp{ID: "1", Kind: "magnify", Payload: json.RawMessage(`{"srcX":0,"srcY":0,"srcW":8,"srcH":8,"x":20,"y":0,"zoom":4,"shape":"rect","filter":"nearest","color":"#00ff00","strokeWidth":1}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	for _, pt := range []image.Point{{28, 12}, {31, 15}} {
+		if got := img.RGBAAt(pt.X, pt.Y); got != (color.RGBA{R: 255, A: 255}) {
+			t.Fatalf("expected magnified red pixel at %v, got %v", pt, got)
+		}
+	}
+	if got := img.RGBAAt(32, 12); got.R != 0 {
+		t.Fatalf("expected hard pixel edge at x=32, got %v", got)
+	}
+}
+
+func TestApplySpotlightDimsOnlyOutsideRegions(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	op := core.AnnotationOp{ID: "1", Kind: "spotlight", Payload: json.RawMessage(`{"regions":[{"x":10,"y":10,"w":20,"h":20,"shape":"ellipse"}],"dim":0.5,"desaturate":true}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(20, 20); got != (color.RGBA{R: 200, G: 200, B: 200, A: 200}) {
+		t.Fatalf("expected region centre untouched, got %v", got)
+	}
+	if got := img.RGBAAt(2, 2); got.R != 100 || got.A != 228 {
+		t.Fatalf("expected dimmed outside, got %v", got)
+	}
+	if got := img.RGBAAt(11, 11); got.R == 200 {
+		t.Fatalf("expected ellipse corner to be dimmed, got %v", got)
+	}
+}
+
+func TestRedactNoiseIgnoresOriginalPixels(t *testing.T) {
+	op := core.AnnotationOp{ID: "1", Kind: "redact", Payload: json.RawMessage(`{"x":4,"y":4,"w":20,"h":12,"mode":"noise","size":4,"seed":42}`)}
+	a := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	b := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	for i := range b.Pix {
+		b.Pix[i] = uint8(i)
+	}
+	for _, img := range []*image.RGBA{a, b} {
+		if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+			t.Fatalf("apply ops: %v", err)
+		}
+	}
+	for y := 4; y < 16; y++ {
+		for x := 4; x < 24; x++ {
+			if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+				t.Fatalf("redacted pixel (%d,%d) depends on the original content", x, y)
+			}
+		}
+	}
+}
+
+func TestVerifyRedactionsDetectsLeakedPixels(t *testing.T) {
+	original := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := range original.Pix {
+		original.Pix[i] = 200
+	}
+	ops := []core.AnnotationOp{{ID: "r", Kind: "redact", Payload: json.RawMessage(`{"x":2,"y":2,"w":10,"h":10}`)}}
+
+	canvas := image.NewRGBA(original.Rect)
+	copy(canvas.Pix, original.Pix)
+	if _, err := VerifyRedactions(original, canvas, ops); err == nil {
+		t.Fatal("expected unredacted canvas to fail verification")
+	}
+
+	if err := ApplyOps(canvas, ops); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	areas, err := VerifyRedactions(original, canvas, ops)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if len(areas) != 1 || areas[0] != (core.Rect{X: 2, Y: 2, W: 10, H: 10}) {
+		t.Fatalf("unexpected redacted areas %v", areas)
+	}
+}
+
+func TestRenderTransformBringsOffCanvasOpIntoView(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	half := 0.5
+	op := core.AnnotationOp{
+		ID:        "1",
+		Kind:      "rect",
+		Payload:   json.RawMessage(`{"x":-30,"y":-30,"w":20,"h":20,"color":"#000000","fill":true}`),
+		Transform: &core.Transform{ScaleX: &half, ScaleY: &half, TranslateX: 50, TranslateY: 50},
+	}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	// Op space (-30,-30)-(-10,-10) lands on (35,35)-(45,45).
+	if got := img.RGBAAt(37, 37); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected the scaled rect inside its footprint, got %v", got)
+	}
+	if got := img.RGBAAt(30, 37).A; got != 0 {
+		t.Fatalf("expected nothing outside the footprint, got alpha %d", got)
+	}
+}
+
+func TestRenderTransformRotatesAroundPivot(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	op := core.AnnotationOp{
+		ID:        "1",
+		Kind:      "rect",
+		Payload:   json.RawMessage(`{"x":10,"y":18,"w":10,"h":4,"color":"#000000","fill":true}`),
+		Transform: &core.Transform{Rotate: 90, PivotX: 15, PivotY: 20},
+	}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	// The 10x4 bar becomes a 4x10 bar around the same centre.
+	for _, pt := range []image.Point{{13, 15}, {16, 24}, {15, 20}} {
+		if got := img.RGBAAt(pt.X, pt.Y).A; got != 255 {
+			t.Fatalf("expected rotated fill at %v, got alpha %d", pt, got)
+		}
+	}
+	for _, pt := range []image.Point{{11, 20}, {18, 20}, {15, 14}, {15, 25}} {
+		if got := img.RGBAAt(pt.X, pt.Y).A; got != 0 {
+			t.Fatalf("expected no fill at %v, got alpha %d", pt, got)
+		}
+	}
+}
+
+func TestRenderTransformKeepsHighlightBlend(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 20, 20, 20, 255
+	}
+	op := core.AnnotationOp{
+		ID:        "1",
+		Kind:      "highlight",
+		Payload:   json.RawMessage(`{"x":-10,"y":0,"w":20,"h":10,"color":"#ffff00"}`),
+		Transform: &core.Transform{TranslateX: 10},
+	}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(5, 5); got != (color.RGBA{R: 20, G: 20, A: 255}) {
+		t.Fatalf("expected multiplied highlight after translation, got %v", got)
+	}
+}
+
+func TestRenderPolygonFillRules(t *testing.T) {
+	// A pentagram: its centre has winding number 2, so only nonzero fills it.
+	star := `"points":[{"x":50,"y":5},{"x":78,"y":90},{"x":5,"y":35},{"x":95,"y":35},{"x":22,"y":90}]`
+	for rule, wantCentre := range map[string]uint8{"nonzero": 255, "evenodd": 0} {
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		payload := `{` + star + `,"color":"#000000","strokeWidth":1,"fill":true,"fillColor":"#0000ff","fillRule":"` + rule + `"}`
+		op := core.AnnotationOp{ID: "1", Kind: "polygon", Payload: json.RawMessage(payload)}
+		if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+			t.Fatalf("apply ops: %v", err)
+		}
+		if got := img.RGBAAt(50, 52).B; got != wantCentre {
+			t.Fatalf("%s: expected centre blue %d, got %d", rule, wantCentre, got)
+		}
+		if got := img.RGBAAt(50, 25).B; got != 255 {
+			t.Fatalf("%s: expected top point filled, got %d", rule, got)
+		}
+	}
+}
+
+func TestRenderOpenPolygonSkipsClosingEdge(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	op := core.AnnotationOp{ID: "1", Kind: "polygon", Payload: json.RawMessage(`{"points":[{"x":10,"y":50},{"x":10,"y":10},{"x":50,"y":10},{"x":50,"y":50}],"open":true,"color":"#000000","strokeWidth":2}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(30, 10).A; got != 255 {
+		t.Fatalf("expected top edge, got alpha %d", got)
+	}
+	if got := img.RGBAAt(30, 50).A; got != 0 {
+		t.Fatalf("expected no closing edge, got alpha %d", got)
+	}
+}
+
+func TestRenderCurvePassesThroughBezierMidpoint(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	// B(0.5) of this quadratic is (50, 30); the straight chord is at y=50.
+	op := core.AnnotationOp{ID: "1", Kind: "curve", Payload: json.RawMessage(`{"x1":10,"y1":50,"x2":90,"y2":50,"c1":{"x":50,"y":10},"color":"#000000","strokeWidth":2}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(50, 29).A; got != 255 {
+		t.Fatalf("expected curve at its midpoint, got alpha %d", got)
+	}
+	if got := img.RGBAAt(50, 49).A; got != 0 {
+		t.Fatalf("expected nothing on the chord, got alpha %d", got)
+	}
+}
+
+func TestRenderArrowHeadStyles(t *testing.T) {
+	for head, filled := range map[string][]image.Point{
+		"triangle": {{85, 50}},
+		"double":   {{85, 50}, {15, 50}},
+		"dot":      {{90, 46}},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		op := core.AnnotationOp{ID: "1", Kind: "arrow", Payload: json.RawMessage(`{"x1":10,"y1":50,"x2":90,"y2":50,"color":"#000000","strokeWidth":1,"headSize":20,"head":"` + head + `"}`)}
+		if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+			t.Fatalf("apply ops: %v", err)
+		}
+		for _, pt := range filled {
+			if got := img.RGBAAt(pt.X, pt.Y-2).A; got != 255 {
+				t.Fatalf("%s: expected filled head near %v, got alpha %d", head, pt, got)
+			}
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	op := core.AnnotationOp{ID: "1", Kind: "arrow", Payload: json.RawMessage(`{"x1":10,"y1":50,"x2":90,"y2":50,"color":"#000000","strokeWidth":1,"headSize":20,"head":"none"}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(85, 46).A; got != 0 {
+		t.Fatalf("expected no head, got alpha %d", got)
+	}
+}
+
+func TestRenderShadowIsOffsetBelowOp(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	op := core.AnnotationOp{
+		ID:      "1",
+		Kind:    "rect",
+		Payload: json.RawMessage(`{"x":10,"y":10,"w":10,"h":10,"color":"#ff0000","fill":true}`),
+		Shadow:  &core.Shadow{X: 5, Y: 5, Color: "#000000"},
+	}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(15, 15); got != (color.RGBA{R: 255, A: 255}) {
+		t.Fatalf("expected the op above its shadow, got %v", got)
+	}
+	if got := img.RGBAAt(22, 22); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected shadow at the offset, got %v", got)
+	}
+	if got := img.RGBAAt(12, 22).A; got != 0 {
+		t.Fatalf("expected nothing outside shadow and op, got alpha %d", got)
+	}
+}
+
+func TestRenderOutlineContrastsWithOp(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	op := core.AnnotationOp{
+		ID:      "1",
+		Kind:    "rect",
+		Payload: json.RawMessage(`{"x":10,"y":10,"w":10,"h":10,"color":"#ffffff","fill":true}`),
+		Outline: &core.Outline{Width: 3},
+	}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(8, 15); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected a dark halo around a white op, got %v", got)
+	}
+	if got := img.RGBAAt(15, 15); got != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatalf("expected the op above its halo, got %v", got)
+	}
+	if got := img.RGBAAt(4, 15); got != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatalf("expected the halo to stop at its width, got %v", got)
+	}
+}
+
+func TestRenderOutlineOfInvisibleOpDrawsNothing(t *testing.T) {
+	for name, op := range map[string]core.AnnotationOp{
+		"off canvas": {Kind: "rect", Payload: json.RawMessage(`{"x":100,"y":100,"w":10,"h":10,"color":"#ff0000","fill":true}`)},
+		"empty text": {Kind: "text", Payload: json.RawMessage(`{"x":5,"y":5,"text":"","color":"#ff0000"}`)},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		op.ID = "1"
+		op.Outline = &core.Outline{}
+		if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+			t.Fatalf("%s: apply ops: %v", name, err)
+		}
+		for i := range img.Pix {
+			if img.Pix[i] != 0 {
+				t.Fatalf("%s: expected an empty canvas", name)
+			}
+		}
+	}
+}
+
+func TestRenderOpacityAndBlendModes(t *testing.T) {
+	half := 0.5
+	cases := []struct {
+		name string
+		op   core.AnnotationOp
+		want color.RGBA
+	}{
+		{"opacity", core.AnnotationOp{Kind: "rect", Payload: json.RawMessage(`{"x":0,"y":0,"w":4,"h":4,"color":"#000000","fill":true}`), Opacity: &half}, color.RGBA{R: 128, G: 128, B: 128, A: 255}},
+		{"difference", core.AnnotationOp{Kind: "rect", Payload: json.RawMessage(`{"x":0,"y":0,"w":4,"h":4,"color":"#ffffff","fill":true}`), Blend: "difference"}, color.RGBA{A: 255}},
+		{"screen", core.AnnotationOp{Kind: "rect", Payload: json.RawMessage(`{"x":0,"y":0,"w":4,"h":4,"color":"#000000","fill":true}`), Blend: "screen"}, color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		{"normal highlight", core.AnnotationOp{Kind: "highlight", Payload: json.RawMessage(`{"x":0,"y":0,"w":4,"h":4,"color":"#0000ff"}`), Blend: "normal"}, color.RGBA{B: 255, A: 255}},
+	}
+	for _, tc := range cases {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := range img.Pix {
+			img.Pix[i] = 255
+		}
+		tc.op.ID = "1"
+		if err := ApplyOps(img, []core.AnnotationOp{tc.op}); err != nil {
+			t.Fatalf("%s: apply ops: %v", tc.name, err)
+		}
+		if got := img.RGBAAt(2, 2); got != tc.want {
+			t.Fatalf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRenderTranslucentPixelEffectMixesWithOriginal(t *testing.T) {
+	zero := 0.0
+	img := blurFixture(32, 32)
+	orig := blurFixture(32, 32)
+	op := core.AnnotationOp{ID: "1", Kind: "pixelate", Payload: json.RawMessage(`{"x":0,"y":0,"w":32,"h":32,"size":8}`), Opacity: &zero}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	for i := range img.Pix {
+		if img.Pix[i] != orig.Pix[i] {
+			t.Fatalf("expected a fully transparent pixelate to leave the image unchanged")
+		}
+	}
+}
+
+func TestRenderBlendedPixelEffectKeepsOutsidePixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	grey := img.RGBAAt(0, 0)
+	img.SetRGBA(12, 12, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	op := core.AnnotationOp{ID: "1", Kind: "blur", Payload: json.RawMessage(`{"x":10,"y":10,"w":5,"h":5,"radius":2}`), Blend: "difference"}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(30, 30); got != grey {
+		t.Fatalf("expected pixel outside the blur to stay %v, got %v", grey, got)
+	}
+	if got := img.RGBAAt(12, 12); got == (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatal("expected the blurred pixel to be blended")
+	}
+}
+
+func TestKeepChangedIsEmptyForUnchangedLayer(t *testing.T) {
+	// A blur over a flat image changes nothing, so renderLayered returns
+	// before blending.
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	layer := image.NewRGBA(img.Rect)
+	copy(layer.Pix, img.Pix)
+	if err := renderOp(layer, core.AnnotationOp{ID: "1", Kind: "blur", Payload: json.RawMessage(`{"x":10,"y":10,"w":5,"h":5,"radius":2}`)}, RenderOptions{}); err != nil {
+		t.Fatalf("render blur: %v", err)
+	}
+	if area := keepChanged(layer, img); !area.Empty() {
+		t.Fatalf("expected no changed area, got %v", area)
+	}
+}
+
+func TestMaskedEffectsOnlyTouchTheShape(t *testing.T) {
+	cases := map[string]string{
+		"ellipse blur":     `{"kind":"blur","payload":{"x":0,"y":0,"w":40,"h":40,"radius":4,"mask":{"shape":"ellipse"}}}`,
+		"polygon pixelate": `{"kind":"pixelate","payload":{"size":6,"mask":{"shape":"polygon","points":[{"x":0,"y":0},{"x":40,"y":0},{"x":0,"y":40}]}}}`,
+		"ellipse invert":   `{"kind":"invert","payload":{"x":0,"y":0,"w":40,"h":40,"mask":{"shape":"ellipse"}}}`,
+	}
+	for name, raw := range cases {
+		var op core.AnnotationOp
+		if err := json.Unmarshal([]byte(raw), &op); err != nil {
+			t.Fatal(err)
+		}
+		op.ID = "1"
+		img := blurFixture(40, 40)
+		orig := blurFixture(40, 40)
+		if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+			t.Fatalf("%s: apply ops: %v", name, err)
+		}
+		// The bottom-right corner is outside both shapes.
+		for y := 36; y < 40; y++ {
+			for x := 36; x < 40; x++ {
+				if img.RGBAAt(x, y) != orig.RGBAAt(x, y) {
+					t.Fatalf("%s: pixel (%d,%d) outside the mask changed", name, x, y)
+				}
+			}
+		}
+		changed := false
+		for y := 10; y < 20; y++ {
+			for x := 10; x < 20; x++ {
+				changed = changed || img.RGBAAt(x, y) != orig.RGBAAt(x, y)
+			}
+		}
+		if !changed {
+			t.Fatalf("%s: expected pixels inside the mask to change", name)
+		}
+	}
+}
+
+func TestMaskFeatherBlendsEdge(t *testing.T) {
+	hard := blurFixture(60, 60)
+	soft := blurFixture(60, 60)
+	orig := blurFixture(60, 60)
+	for img, feather := range map[*image.RGBA]int{hard: 0, soft: 6} {
+		payload := fmt.Sprintf(`{"x":10,"y":10,"w":40,"h":40,"radius":5,"mask":{"shape":"ellipse","feather":%d}}`, feather)
+		if err := ApplyOps(img, []core.AnnotationOp{{ID: "1", Kind: "blur", Payload: json.RawMessage(payload)}}); err != nil {
+			t.Fatalf("apply ops: %v", err)
+		}
+	}
+	// Just outside the ellipse the hard mask leaves pixels alone while the
+	// feather already mixes in some of the blur.
+	if hard.RGBAAt(30, 8) != orig.RGBAAt(30, 8) {
+		t.Fatal("expected hard mask to stop at the ellipse")
+	}
+	if soft.RGBAAt(30, 8) == orig.RGBAAt(30, 8) {
+		t.Fatal("expected feathered mask to reach past the ellipse")
+	}
+}
+
+func TestRenderAdjustments(t *testing.T) {
+	cases := []struct {
+		kind, amount string
+		want         color.RGBA
+	}{
+		{"grayscale", "", color.RGBA{R: 117, G: 117, B: 117, A: 255}},
+		{"invert", "", color.RGBA{R: 55, G: 155, B: 215, A: 255}},
+		{"invert", `,"amount":0.5`, color.RGBA{R: 128, G: 128, B: 128, A: 255}},
+		{"brightness", `,"amount":0.5`, color.RGBA{R: 100, G: 50, B: 20, A: 255}},
+		{"contrast", `,"amount":0`, color.RGBA{R: 128, G: 128, B: 128, A: 255}},
+		{"contrast", `,"amount":2`, color.RGBA{R: 255, G: 73, B: 0, A: 255}},
+		{"saturate", `,"amount":1`, color.RGBA{R: 200, G: 100, B: 40, A: 255}},
+	}
+	for _, tc := range cases {
+		img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+		for i := 0; i < len(img.Pix); i += 4 {
+			copy(img.Pix[i:], []uint8{200, 100, 40, 255})
+		}
+		payload := `{"x":5,"y":5,"w":10,"h":10` + tc.amount + `}`
+		if err := ApplyOps(img, []core.AnnotationOp{{ID: "1", Kind: tc.kind, Payload: json.RawMessage(payload)}}); err != nil {
+			t.Fatalf("%s%s: apply ops: %v", tc.kind, tc.amount, err)
+		}
+		if got := img.RGBAAt(10, 10); got != tc.want {
+			t.Fatalf("%s%s: expected %v inside the box, got %v", tc.kind, tc.amount, tc.want, got)
+		}
+		if got := img.RGBAAt(2, 2); got != (color.RGBA{R: 200, G: 100, B: 40, A: 255}) {
+			t.Fatalf("%s%s: expected pixels outside the box unchanged, got %v", tc.kind, tc.amount, got)
+		}
+	}
+}
+
+func TestRenderAdjustmentKeepsTransparency(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.SetRGBA(1, 1, color.RGBA{R: 100, G: 0, B: 0, A: 128})
+	if err := ApplyOps(img, []core.AnnotationOp{{ID: "1", Kind: "invert", Payload: json.RawMessage(`{"x":0,"y":0,"w":4,"h":4}`)}}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(0, 0); got.A != 0 {
+		t.Fatalf("expected transparent pixels to stay transparent, got %v", got)
+	}
+	if got := img.RGBAAt(1, 1); got.A != 128 || got.G < 120 || got.R > 40 {
+		t.Fatalf("expected inverted color at unchanged alpha, got %v", got)
+	}
+}
+
+func TestMeasureLabel(t *testing.T) {
+	cases := []struct {
+		payload string
+		scale   int
+		want    string
+	}{
+		{`{"x1":10,"y1":5,"x2":110,"y2":5}`, 2, "100 px"},
+		{`{"x1":0,"y1":0,"x2":30,"y2":40}`, 1, "50 px"},
+		{`{"x1":0,"y1":0,"x2":10,"y2":10}`, 1, "14.1 px"},
+		{`{"x1":10,"y1":5,"x2":110,"y2":5,"unit":"pt"}`, 2, "50 pt"},
+		{`{"x1":10,"y1":5,"x2":111,"y2":5,"unit":"pt"}`, 2, "50.5 pt"},
+		{`{"x1":10,"y1":5,"x2":110,"y2":5,"unit":"pt"}`, 0, "100 pt"},
+		{`{"x1":10,"y1":5,"x2":110,"y2":5,"label":"gutter"}`, 2, "gutter"},
+	}
+	for _, tc := range cases {
+		var p MeasurePayload
+		if err := json.Unmarshal([]byte(tc.payload), &p); err != nil {
+			t.Fatal(err)
+		}
+		if got := measureLabel(p, tc.scale); got != tc.want {
+			t.Fatalf("%s at scale %d: expected %q, got %q", tc.payload, tc.scale, tc.want, got)
+		}
+	}
+}
+
+func TestRenderMeasureDrawsTicksAndLabel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 60))
+	op := core.AnnotationOp{ID: "1", Kind: "measure", Payload: json.RawMessage(`{"x1":20,"y1":30,"x2":180,"y2":30,"color":"#ff0000"}`)}
+	if err := ApplyOpsWithOptions(img, []core.AnnotationOp{op}, RenderOptions{Scale: 2}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	red := color.RGBA{R: 255, A: 255}
+	// The ticks reach well above and below the line at both ends.
+	for _, x := range []int{20, 179} {
+		if got := img.RGBAAt(x, 25); got != red {
+			t.Fatalf("expected tick at x=%d, got %v", x, got)
+		}
+		if got := img.RGBAAt(x, 34); got != red {
+			t.Fatalf("expected tick at x=%d, got %v", x, got)
+		}
+	}
+	if got := img.RGBAAt(40, 25); got.A != 0 {
+		t.Fatalf("expected no ink above the line away from the ends, got %v", got)
+	}
+	// The label pill covers the middle of the line, with white text on it.
+	white := false
+	for y := 22; y < 38; y++ {
+		for x := 80; x < 120; x++ {
+			c := img.RGBAAt(x, y)
+			white = white || (c.G > 200 && c.B > 200)
+		}
+	}
+	if !white {
+		t.Fatal("expected white label text at the midpoint")
+	}
+	if got := img.RGBAAt(100, 24); got.A == 0 {
+		t.Fatalf("expected the label pill above the line at the midpoint, got %v", got)
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	cases := map[string][]string{
+		"Ctrl+Shift+P": {"Ctrl", "Shift", "P"},
+		"Ctrl + K":     {"Ctrl", "K"},
+		"Cmd++":        {"Cmd", "+"},
+		"+":            {"+"},
+		"Ctrl++ + A":   {"Ctrl", "+", "A"},
+		"Esc":          {"Esc"},
+	}
+	for in, want := range cases {
+		if got := splitKeys(in); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%q: expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestRenderKeysThemes(t *testing.T) {
+	for theme, face := range map[string]color.NRGBA{"": keyThemes["light"].face, "dark": keyThemes["dark"].face} {
+		img := image.NewRGBA(image.Rect(0, 0, 200, 60))
+		payload := fmt.Sprintf(`{"x":10,"y":10,"keys":"A+B","theme":%q}`, theme)
+		if err := ApplyOps(img, []core.AnnotationOp{{ID: "1", Kind: "keys", Payload: json.RawMessage(payload)}}); err != nil {
+			t.Fatalf("%q: apply ops: %v", theme, err)
+		}
+		// Near the top-left corner of the first cap, inside its border but
+		// clear of the label.
+		want := color.RGBA{R: face.R, G: face.G, B: face.B, A: 255}
+		if got := img.RGBAAt(14, 14); got != want {
+			t.Fatalf("%q: expected cap face %v, got %v", theme, want, got)
+		}
+		// Single letters get square caps, so the second cap starts within a
+		// few cap widths and the row ends well before the image edge.
+		if got := img.RGBAAt(150, 20); got.A != 0 {
+			t.Fatalf("%q: expected the row to end before x=150, got %v", theme, got)
+		}
+		inked := 0
+		for x := 40; x < 100; x++ {
+			if img.RGBAAt(x, 20).A != 0 {
+				inked++
+			}
+		}
+		if inked < 20 {
+			t.Fatalf("%q: expected a separator and second cap after the first, got %d inked pixels", theme, inked)
+		}
+	}
+}
+
+func TestRenderCursorHotspotAndScale(t *testing.T) {
+	extent := func(displayScale int, payload string) image.Rectangle {
+		img := image.NewRGBA(image.Rect(0, 0, 120, 120))
+		op := core.AnnotationOp{ID: "1", Kind: "cursor", Payload: json.RawMessage(payload)}
+		if err := ApplyOpsWithOptions(img, []core.AnnotationOp{op}, RenderOptions{Scale: displayScale}); err != nil {
+			t.Fatalf("apply ops: %v", err)
+		}
+		return inkBounds(img)
+	}
+	// The arrow's tip, inside its white rim, sits on the hotspot.
+	retina := extent(2, `{"x":40,"y":40}`)
+	if !image.Pt(40, 40).In(retina) || retina.Min.X < 37 || retina.Min.Y < 37 {
+		t.Fatalf("expected the arrow to start at the hotspot, got %v", retina)
+	}
+	// At 2x the bitmap is drawn pixel for pixel; 1x halves it and Scale
+	// multiplies on top of the display scale.
+	if got := retina.Size(); got != cursorInkSize(t, "arrow") {
+		t.Fatalf("expected the 2x arrow unscaled, got %v", got)
+	}
+	if got := extent(1, `{"x":40,"y":40}`).Dy(); got > retina.Dy()/2+2 {
+		t.Fatalf("expected a 1x arrow about half as tall as %d, got %d", retina.Dy(), got)
+	}
+	if got := extent(1, `{"x":40,"y":40,"scale":2}`).Size(); got != retina.Size() {
+		t.Fatalf("expected scale 2 at 1x to match 2x, got %v want %v", got, retina.Size())
+	}
+	ibeam := extent(2, `{"x":60,"y":60,"shape":"ibeam"}`)
+	if d := ibeam.Min.Add(ibeam.Max).Div(2).Sub(image.Pt(60, 60)); d.X < -1 || d.X > 1 || d.Y < -1 || d.Y > 1 {
+		t.Fatalf("expected the I-beam centred on its hotspot, got %v", ibeam)
+	}
+}
+
+func cursorInkSize(t *testing.T, shape string) image.Point {
+	t.Helper()
+	images, err := cursorImages()
+	if err != nil {
+		t.Fatalf("load cursors: %v", err)
+	}
+	src := images[shape]
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Rect, src, img.Rect.Min, draw.Src)
+	return inkBounds(img).Size()
+}
+
+func TestRenderClickRipple(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+	op := core.AnnotationOp{ID: "1", Kind: "click", Payload: json.RawMessage(`{"x":40,"y":40,"color":"#ff0000","radius":20}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	dot, inner, outer := img.RGBAAt(40, 40), img.RGBAAt(40+12, 40), img.RGBAAt(40+19, 40)
+	if dot.R == 0 || dot.G != 0 {
+		t.Fatalf("expected a red dot at the click, got %v", dot)
+	}
+	if !(dot.A > inner.A && inner.A > outer.A && outer.A > 0) {
+		t.Fatalf("expected rings fading outwards, got dot %v inner %v outer %v", dot, inner, outer)
+	}
+	if got := img.RGBAAt(40+22, 40); got.A != 0 {
+		t.Fatalf("expected nothing past the radius, got %v", got)
+	}
+}
+
+func TestRenderAutoColorPicksMostContrasting(t *testing.T) {
+	palette := []string{"#ff0000", "#000000", "#ffffff"}
+	cases := []struct {
+		bg   color.RGBA
+		want color.RGBA
+	}{
+		{color.RGBA{R: 255, G: 255, B: 255, A: 255}, color.RGBA{A: 255}},
+		{color.RGBA{A: 255}, color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		{color.RGBA{R: 255, A: 255}, color.RGBA{A: 255}},
+	}
+	for _, tc := range cases {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		draw.Draw(img, img.Rect, image.NewUniform(tc.bg), image.Point{}, draw.Src)
+		op := core.AnnotationOp{ID: "1", Kind: "rect", Payload: json.RawMessage(`{"x":10,"y":10,"w":20,"h":20,"color":"auto","strokeWidth":4}`)}
+		if err := ApplyOpsWithOptions(img, []core.AnnotationOp{op}, RenderOptions{Palette: palette}); err != nil {
+			t.Fatalf("apply ops: %v", err)
+		}
+		if got := img.RGBAAt(10, 20); got != tc.want {
+			t.Fatalf("on %v: expected %v, got %v", tc.bg, tc.want, got)
+		}
+	}
+}
+
+func TestRenderAutoColorAvoidsBackgroundHue(t *testing.T) {
+	// A red rectangle on a red error banner is the case auto color is for:
+	// the default palette has red, but the renderer must not choose it.
+	img := image.NewRGBA(image.Rect(0, 0, 60, 40))
+	banner := color.RGBA{R: 0xff, G: 0x3b, B: 0x30, A: 255}
+	draw.Draw(img, img.Rect, image.NewUniform(banner), image.Point{}, draw.Src)
+	op := core.AnnotationOp{ID: "1", Kind: "ellipse", Payload: json.RawMessage(`{"x":10,"y":5,"w":40,"h":30,"color":"auto","strokeWidth":4}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(30, 6); got == banner {
+		t.Fatalf("expected an auto color different from the banner, got %v", got)
+	}
+	// Only the footprint counts: on a white canvas with a red patch far from
+	// the op, the same ellipse is judged against white.
+	white := image.NewRGBA(image.Rect(0, 0, 60, 40))
+	draw.Draw(white, white.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(white, image.Rect(0, 0, 4, 4), image.NewUniform(banner), image.Point{}, draw.Src)
+	if err := ApplyOpsWithOptions(white, []core.AnnotationOp{op}, RenderOptions{Palette: []string{"#ffff00", "#0000ff"}}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := white.RGBAAt(30, 6); got != (color.RGBA{B: 255, A: 255}) {
+		t.Fatalf("expected blue on white, got %v", got)
+	}
+}
+
+func TestRenderHugeGeometryStaysBounded(t *testing.T) {
+	if n := len(ellipsePoints(fpoint{}, 4e8, 4e8)); n > maxEllipseSegments {
+		t.Fatalf("expected at most %d ellipse vertices, got %d", maxEllipseSegments, n)
+	}
+	pieces := dashPolyline([]fpoint{{-2e9, 10}, {2e9, 10}}, []float64{1, 1})
+	if len(pieces) > maxDashPieces+1 {
+		t.Fatalf("expected at most %d dash pieces, got %d", maxDashPieces+1, len(pieces))
+	}
+	// The last piece runs solid to the end of the path.
+	if last := pieces[len(pieces)-1]; last[len(last)-1] != (fpoint{2e9, 10}) {
+		t.Fatalf("expected the remainder drawn solid to the end, got %v", last[len(last)-1])
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	ops := []core.AnnotationOp{
+		{ID: "1", Kind: "ellipse", Payload: json.RawMessage(`{"x":-200000000,"y":-200000000,"w":400000000,"h":400000000,"color":"#ff0000","fill":true}`)},
+		{ID: "2", Kind: "line", Payload: json.RawMessage(`{"x1":-2000000000,"y1":10,"x2":2000000000,"y2":10,"color":"#0000ff","dash":[1,1]}`)},
+	}
+	if err := ValidateOps(ops); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if err := ApplyOps(img, ops); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if got := img.RGBAAt(32, 40); got != (color.RGBA{R: 255, A: 255}) {
+		t.Fatalf("expected the canvas inside the huge ellipse filled, got %v", got)
+	}
+}
+
+func TestRenderMagnifyClipsLensToCanvas(t *testing.T) {
+	// A lens far larger than the canvas only scales the visible part, and
+	// that part still lines up with the full lens.
+	img := blurFixture(64, 48)
+	orig := blurFixture(64, 48)
+	op := core.AnnotationOp{ID: "1", Kind: "magnify", Payload: json.RawMessage(`{"srcX":0,"srcY":0,"srcW":64,"srcH":48,"x":0,"y":0,"zoom":16,"shape":"rect","filter":"nearest","color":"#ff0000","strokeWidth":1}`)}
+	if err := ApplyOps(img, []core.AnnotationOp{op}); err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	// Each source pixel covers a 16x16 block, so canvas pixel (20, 20) shows
+	// source pixel (1, 1).
+	if got, want := img.RGBAAt(20, 20), orig.RGBAAt(1, 1); got != want {
+		t.Fatalf("expected magnified source pixel %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,183 @@
+package annotate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+const maxOutlineWidth = 16
+
+const defaultShadowColor = "rgba(0,0,0,0.5)"
+
+func validateDecorations(op core.AnnotationOp) error {
+	if op.Shadow == nil && op.Outline == nil {
+		return nil
+	}
+	if _, ok := pixelEffectKinds[op.Kind]; ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: fmt.Sprintf("shadow and outline are not supported for %s op: %s", op.Kind, op.ID)}
+	}
+	if s := op.Shadow; s != nil {
+		if s.Blur < 0 {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "shadow blur must not be negative: " + op.ID}
+		}
+		if err := validateColors(s.Color); err != nil {
+			return err
+		}
+	}
+	if o := op.Outline; o != nil {
+		if o.Width < 0 || o.Width > maxOutlineWidth {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "outline width out of range: " + op.ID}
+		}
+		if err := validateColors(o.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inkBounds is the smallest rectangle holding every non-transparent pixel.
+func inkBounds(img *image.RGBA) image.Rectangle {
+	b := img.Rect
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X, b.Min.Y
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()*4]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] == 0 {
+				continue
+			}
+			x := b.Min.X + i/4
+			minX, maxX = min(minX, x), max(maxX, x+1)
+			minY, maxY = min(minY, y), max(maxY, y+1)
+		}
+	}
+	// image.Rect would swap the untouched, inverted bounds into the whole
+	// image.
+	if minX >= maxX {
+		return image.Rectangle{}
+	}
+	return image.Rect(minX, minY, maxX, maxY).Intersect(b)
+}
+
+func drawShadow(dst draw.Image, layer *image.RGBA, ink image.Rectangle, s *core.Shadow) error {
+	col := s.Color
+	if col == "" {
+		col = defaultShadowColor
+	}
+	c, err := parseColor(col)
+	if err != nil {
+		return err
+	}
+	offset := image.Pt(s.X, s.Y)
+	area := ink.Add(offset).Inset(-3*s.Blur - 1).Intersect(dst.Bounds())
+	if area.Empty() {
+		return nil
+	}
+	shadow := image.NewRGBA(area)
+	for y := ink.Min.Y; y < ink.Max.Y; y++ {
+		for x := ink.Min.X; x < ink.Max.X; x++ {
+			p := image.Pt(x, y).Add(offset)
+			a := layer.Pix[layer.PixOffset(x, y)+3]
+			if a == 0 || !p.In(area) {
+				continue
+			}
+			shadow.SetRGBA(p.X, p.Y, premultiplied(c, a))
+		}
+	}
+	if s.Blur > 0 {
+		gaussianBlur(shadow, area, float64(s.Blur))
+	}
+	draw.Draw(dst, area, shadow, area.Min, draw.Over)
+	return nil
+}
+
+// drawOutline dilates the layer's alpha with a disc Width pixels in radius.
+// The disc's rim is weighted by how much of each pixel it covers, so the
+// halo edge stays anti-aliased.
+func drawOutline(dst draw.Image, layer *image.RGBA, ink image.Rectangle, o *core.Outline) error {
+	width := o.Width
+	if width == 0 {
+		width = 2
+	}
+	var c color.NRGBA
+	if o.Color != "" {
+		var err error
+		if c, err = parseColor(o.Color); err != nil {
+			return err
+		}
+	} else {
+		c = contrastingColor(layer, ink)
+	}
+
+	type tap struct {
+		dx, dy int
+		weight uint32
+	}
+	var taps []tap
+	reach := float64(width) + 0.5
+	for dy := -width; dy <= width; dy++ {
+		for dx := -width; dx <= width; dx++ {
+			w := math.Min(reach-math.Hypot(float64(dx), float64(dy)), 1)
+			if w > 0 {
+				taps = append(taps, tap{dx, dy, uint32(math.Round(w * 255))})
+			}
+		}
+	}
+
+	area := ink.Inset(-width).Intersect(dst.Bounds())
+	mask := image.NewAlpha(area)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			var best uint32
+			for _, t := range taps {
+				p := image.Pt(x+t.dx, y+t.dy)
+				if !p.In(ink) {
+					continue
+				}
+				if a := uint32(layer.Pix[layer.PixOffset(p.X, p.Y)+3]) * t.weight / 255; a > best {
+					best = a
+					if best == 255 {
+						break
+					}
+				}
+			}
+			mask.Pix[mask.PixOffset(x, y)] = uint8(best)
+		}
+	}
+	paintMask(dst, mask, c)
+	return nil
+}
+
+// contrastingColor picks black or white against the op's average ink.
+func contrastingColor(layer *image.RGBA, ink image.Rectangle) color.NRGBA {
+	var r, g, b, a uint64
+	for y := ink.Min.Y; y < ink.Max.Y; y++ {
+		for x := ink.Min.X; x < ink.Max.X; x++ {
+			c := layer.RGBAAt(x, y)
+			r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+		}
+	}
+	if a == 0 {
+		return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	mean := color.RGBA{R: uint8(r * 255 / a), G: uint8(g * 255 / a), B: uint8(b * 255 / a), A: 255}
+	if luma(mean) >= 128 {
+		return color.NRGBA{A: 255}
+	}
+	return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+}
+
+// premultiplied scales c by coverage a into a premultiplied pixel.
+func premultiplied(c color.NRGBA, a uint8) color.RGBA {
+	alpha := (uint32(c.A)*uint32(a) + 127) / 255
+	return color.RGBA{
+		R: uint8((uint32(c.R)*alpha + 127) / 255),
+		G: uint8((uint32(c.G)*alpha + 127) / 255),
+		B: uint8((uint32(c.B)*alpha + 127) / 255),
+		A: uint8(alpha),
+	}
+}
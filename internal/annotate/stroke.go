@@ -0,0 +1,244 @@
+package annotate
+
+import (
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Cap and join names follow CanvasRenderingContext2D.lineCap/lineJoin so the
+// frontend preview can pass payload values straight through.
+const (
+	capButt   = "butt"
+	capRound  = "round"
+	capSquare = "square"
+
+	joinMiter = "miter"
+	joinRound = "round"
+	joinBevel = "bevel"
+
+	miterLimit = 10
+)
+
+var (
+	knownCaps  = map[string]struct{}{"": {}, capButt: {}, capRound: {}, capSquare: {}}
+	knownJoins = map[string]struct{}{"": {}, joinMiter: {}, joinRound: {}, joinBevel: {}}
+)
+
+type strokeStyle struct {
+	width float64
+	cap   string
+	join  string
+	dash  []float64
+}
+
+func newStrokeStyle(width int, cap, join string, dash []float64) strokeStyle {
+	w := float64(width)
+	if w <= 0 {
+		w = 2
+	}
+	return strokeStyle{width: w, cap: cap, join: join, dash: dash}
+}
+
+// strokePath rasterizes the outline of pts as one anti-aliased shape, so
+// overlapping segments, joins and caps never double-blend.
+func strokePath(dst draw.Image, pts []fpoint, closed bool, s strokeStyle, c color.Color) {
+	paintMask(dst, rasterize(s.outline(pts, closed), fillNonZero, dst.Bounds()), c)
+}
+
+// outline converts a path into polygons covering its stroke. Every polygon
+// is wound the same way so their union can be filled with the nonzero rule.
+func (s strokeStyle) outline(pts []fpoint, closed bool) [][]fpoint {
+	pts = dedupePoints(pts)
+	if len(pts) == 0 {
+		return nil
+	}
+	pattern := dashPattern(s.dash)
+	if closed && len(pts) > 2 && pattern == nil {
+		return s.outlineClosed(pts)
+	}
+	if closed && len(pts) > 1 {
+		pts = append(pts[:len(pts):len(pts)], pts[0])
+	}
+	pieces := [][]fpoint{pts}
+	if pattern != nil {
+		pieces = dashPolyline(pts, pattern)
+	}
+	var polys [][]fpoint
+	for _, piece := range pieces {
+		polys = append(polys, s.outlineOpen(dedupePoints(piece))...)
+	}
+	return polys
+}
+
+func (s strokeStyle) outlineOpen(pts []fpoint) [][]fpoint {
+	hw := s.width / 2
+	if len(pts) == 1 {
+		switch s.cap {
+		case capRound:
+			return [][]fpoint{discPoints(pts[0], hw)}
+		case capSquare:
+			p := pts[0]
+			return [][]fpoint{{{p.x - hw, p.y - hw}, {p.x + hw, p.y - hw}, {p.x + hw, p.y + hw}, {p.x - hw, p.y + hw}}}
+		}
+		return nil
+	}
+	if s.cap == capSquare {
+		pts = append([]fpoint(nil), pts...)
+		last := len(pts) - 1
+		pts[0] = pts[0].add(unit(pts[0].sub(pts[1])).scale(hw))
+		pts[last] = pts[last].add(unit(pts[last].sub(pts[last-1])).scale(hw))
+	}
+	var polys [][]fpoint
+	for i := 0; i+1 < len(pts); i++ {
+		polys = append(polys, segmentQuad(pts[i], pts[i+1], hw))
+	}
+	for i := 1; i+1 < len(pts); i++ {
+		if j := s.joinPolygon(pts[i-1], pts[i], pts[i+1]); j != nil {
+			polys = append(polys, j)
+		}
+	}
+	if s.cap == capRound {
+		polys = append(polys, discPoints(pts[0], hw), discPoints(pts[len(pts)-1], hw))
+	}
+	return polys
+}
+
+func (s strokeStyle) outlineClosed(pts []fpoint) [][]fpoint {
+	hw := s.width / 2
+	n := len(pts)
+	var polys [][]fpoint
+	for i := range pts {
+		polys = append(polys, segmentQuad(pts[i], pts[(i+1)%n], hw))
+	}
+	for i := range pts {
+		if j := s.joinPolygon(pts[(i+n-1)%n], pts[i], pts[(i+1)%n]); j != nil {
+			polys = append(polys, j)
+		}
+	}
+	return polys
+}
+
+// joinPolygon fills the wedge on the outer side of the corner at v.
+func (s strokeStyle) joinPolygon(prev, v, next fpoint) []fpoint {
+	hw := s.width / 2
+	d0 := unit(v.sub(prev))
+	d1 := unit(next.sub(v))
+	cross := d0.x*d1.y - d0.y*d1.x
+	if math.Abs(cross) < 1e-9 && d0.x*d1.x+d0.y*d1.y > 0 {
+		return nil
+	}
+	if s.join == joinRound {
+		return discPoints(v, hw)
+	}
+	side := -1.0
+	if cross < 0 {
+		side = 1
+	}
+	n0 := perp(d0).scale(hw * side)
+	n1 := perp(d1).scale(hw * side)
+	a := v.add(n0)
+	b := v.add(n1)
+	if s.join != joinBevel {
+		u := n0.add(n1).scale(0.5)
+		if ul2 := u.x*u.x + u.y*u.y; ul2 > 0 && hw*hw/ul2 <= miterLimit*miterLimit {
+			return oriented([]fpoint{v, a, v.add(u.scale(hw * hw / ul2)), b})
+		}
+	}
+	return oriented([]fpoint{v, a, b})
+}
+
+func segmentQuad(a, b fpoint, hw float64) []fpoint {
+	n := perp(unit(b.sub(a))).scale(hw)
+	return oriented([]fpoint{a.add(n), b.add(n), b.sub(n), a.sub(n)})
+}
+
+// dashPattern normalises a canvas-style dash array: odd-length patterns
+// repeat twice, and an all-zero pattern means a solid line.
+func dashPattern(dash []float64) []float64 {
+	total := 0.0
+	for _, d := range dash {
+		total += d
+	}
+	if total <= 0 {
+		return nil
+	}
+	if len(dash)%2 == 1 {
+		return append(append([]float64(nil), dash...), dash...)
+	}
+	return dash
+}
+
+// maxDashPieces bounds how many dashes one path is split into. A very long
+// path with a short pattern is drawn solid past that point rather than
+// allocating without limit.
+const maxDashPieces = 1 << 16
+
+// dashPolyline splits pts into the "on" pieces of pattern, measured along
+// the path's arc length from its first point.
+func dashPolyline(pts []fpoint, pattern []float64) [][]fpoint {
+	var pieces [][]fpoint
+	idx := 0
+	remain := pattern[0]
+	on := true
+	cur := []fpoint{pts[0]}
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		length := math.Hypot(b.x-a.x, b.y-a.y)
+		pos := 0.0
+		for length-pos > remain {
+			if len(pieces) >= maxDashPieces {
+				if !on {
+					cur = []fpoint{a.add(b.sub(a).scale(pos / length))}
+				}
+				return append(pieces, append(cur, pts[i+1:]...))
+			}
+			pos += remain
+			pt := a.add(b.sub(a).scale(pos / length))
+			if on {
+				pieces = append(pieces, append(cur, pt))
+				cur = nil
+			} else {
+				cur = []fpoint{pt}
+			}
+			on = !on
+			idx = (idx + 1) % len(pattern)
+			remain = pattern[idx]
+		}
+		remain -= length - pos
+		if on {
+			cur = append(cur, b)
+		}
+	}
+	if on && len(cur) > 1 {
+		pieces = append(pieces, cur)
+	}
+	return pieces
+}
+
+func dedupePoints(pts []fpoint) []fpoint {
+	out := make([]fpoint, 0, len(pts))
+	for i, p := range pts {
+		if i > 0 && p == out[len(out)-1] {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// oriented returns poly wound clockwise in screen space (positive shoelace
+// area with y pointing down).
+func oriented(poly []fpoint) []fpoint {
+	area := 0.0
+	for i, a := range poly {
+		b := poly[(i+1)%len(poly)]
+		area += a.x*b.y - b.x*a.y
+	}
+	if area < 0 {
+		for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+			poly[i], poly[j] = poly[j], poly[i]
+		}
+	}
+	return poly
+}
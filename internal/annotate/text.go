@@ -0,0 +1,195 @@
+package annotate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+const defaultTextSize = 18
+
+// Go Mono is embedded in golang.org/x/image, so exports never depend on the
+// fonts installed on the machine. It matches the monospace preview font used
+// by the frontend. Its WGL4 character set has no CJK, Arabic, Hebrew or Indic
+// glyphs and there is no fallback face, so those draw as .notdef boxes.
+var (
+	regularFont = sync.OnceValues(func() (*opentype.Font, error) { return opentype.Parse(gomono.TTF) })
+	boldFont    = sync.OnceValues(func() (*opentype.Font, error) { return opentype.Parse(gomonobold.TTF) })
+)
+
+// textBlock is laid-out, possibly multi-line text at a fixed pixel size.
+// Positions passed to its methods are the left edge and the baseline of the
+// first line, the same anchor CanvasRenderingContext2D.fillText uses.
+type textBlock struct {
+	face       font.Face
+	lines      []string
+	width      int
+	ascent     int
+	descent    int
+	lineHeight int
+}
+
+func newTextBlock(text string, size int, bold bool) (*textBlock, error) {
+	if size <= 0 {
+		size = defaultTextSize
+	}
+	load := regularFont
+	if bold {
+		load = boldFont
+	}
+	f, err := load()
+	if err != nil {
+		return nil, &core.AppError{Code: core.ErrRenderFailed, Message: "load font: " + err.Error()}
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, &core.AppError{Code: core.ErrRenderFailed, Message: "load font face: " + err.Error()}
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	m := face.Metrics()
+	b := &textBlock{
+		face:       face,
+		lines:      strings.Split(text, "\n"),
+		ascent:     m.Ascent.Ceil(),
+		descent:    m.Descent.Ceil(),
+		lineHeight: m.Height.Ceil(),
+	}
+	for _, line := range b.lines {
+		b.width = max(b.width, font.MeasureString(face, line).Ceil())
+	}
+	return b, nil
+}
+
+func (b *textBlock) close() {
+	_ = b.face.Close()
+}
+
+// height is the distance from the top of the first line to the bottom of the
+// last one.
+func (b *textBlock) height() int {
+	return b.ascent + (len(b.lines)-1)*b.lineHeight + b.descent
+}
+
+// bounds is the ink box of the block anchored at (x, baseline).
+func (b *textBlock) bounds(x, baseline int) image.Rectangle {
+	top := baseline - b.ascent
+	return image.Rect(x, top, x+b.width, top+b.height())
+}
+
+func (b *textBlock) draw(dst draw.Image, x, baseline int, c color.Color) {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: b.face}
+	for i, line := range b.lines {
+		d.Dot = fixed.P(x, baseline+i*b.lineHeight)
+		d.DrawString(line)
+	}
+}
+
+// drawCentered draws a single-line block with its ink box centred on c, which
+// centres digits and capitals optically rather than by font metrics.
+func (b *textBlock) drawCentered(dst draw.Image, c fpoint, col color.Color) {
+	ink, _ := font.BoundString(b.face, b.lines[0])
+	midX := (ink.Min.X + ink.Max.X) / 2
+	midY := (ink.Min.Y + ink.Max.Y) / 2
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: b.face}
+	d.Dot = fixed.Point26_6{X: floatToFixed(c.x) - midX, Y: floatToFixed(c.y) - midY}
+	d.DrawString(b.lines[0])
+}
+
+func floatToFixed(f float64) fixed.Int26_6 {
+	return fixed.Int26_6(math.Round(f * 64))
+}
+
+func renderText(dst draw.Image, p TextPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	if p.Text == "" {
+		return nil
+	}
+	b, err := newTextBlock(p.Text, p.Size, p.Bold)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	if p.Background != "" {
+		pad := p.Padding
+		if pad <= 0 {
+			pad = 4
+		}
+		bg, err := parseColor(p.Background)
+		if err != nil {
+			return err
+		}
+		draw.Draw(dst, b.bounds(p.X, p.Y).Inset(-pad), image.NewUniform(bg), image.Point{}, draw.Over)
+	}
+	b.draw(dst, p.X, p.Y, c)
+	return nil
+}
+
+func renderCallout(dst draw.Image, p CalloutPayload) error {
+	c, err := parseColor(p.Color)
+	if err != nil {
+		return err
+	}
+	if p.TextColor == "" {
+		p.TextColor = "#ffffff"
+	}
+	tc, err := parseColor(p.TextColor)
+	if err != nil {
+		return err
+	}
+	b, err := newTextBlock(p.Text, p.Size, p.Bold)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	pad := p.Padding
+	if pad <= 0 {
+		pad = 8
+	}
+	radius := p.Radius
+	if radius <= 0 {
+		radius = 6
+	}
+	x0, y0 := float64(p.X), float64(p.Y)
+	x1, y1 := x0+float64(b.width+2*pad), y0+float64(b.height()+2*pad)
+	shapes := [][]fpoint{roundedRectPoints(x0, y0, x1, y1, float64(radius))}
+
+	if p.Anchor != nil {
+		center := fpoint{(x0 + x1) / 2, (y0 + y1) / 2}
+		anchor := fpoint{float64(p.Anchor.X), float64(p.Anchor.Y)}
+		if exit, outside := boxExit(center, anchor, (x1-x0)/2, (y1-y0)/2); outside {
+			switch p.Tail {
+			case "", "bubble":
+				// The tail starts at the box centre and is filled together with
+				// the box, so it tapers out of the edge without a seam.
+				half := math.Min(12, math.Min(x1-x0, y1-y0)/3)
+				n := perp(unit(anchor.sub(center))).scale(half)
+				shapes = append(shapes, oriented([]fpoint{center.add(n), anchor, center.sub(n)}))
+			case "leader":
+				w := p.StrokeWidth
+				if w <= 0 {
+					w = 2
+				}
+				shapes = append(shapes, newStrokeStyle(w, capButt, joinMiter, nil).outline([]fpoint{exit, anchor}, false)...)
+				shapes = append(shapes, discPoints(anchor, float64(w)*1.5+1))
+			}
+		}
+	}
+	fillPolygons(dst, shapes, fillNonZero, c)
+	b.draw(dst, p.X+pad, p.Y+pad+b.ascent, tc)
+	return nil
+}
@@ -0,0 +1,182 @@
+package annotate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+
+	"github.com/mohamoundaljadan/screenshot/internal/core"
+)
+
+// minTransformDet is the smallest area scale a transform may have before it
+// is treated as collapsing the op onto a line or point.
+const minTransformDet = 1e-6
+
+// pixelEffectKinds sample the pixels already on the canvas. Transforming or
+// decorating what they draw would act on image content, not an annotation.
+var pixelEffectKinds = map[string]struct{}{
+	"magnify":    {},
+	"spotlight":  {},
+	"redact":     {},
+	"blur":       {},
+	"pixelate":   {},
+	"grayscale":  {},
+	"invert":     {},
+	"brightness": {},
+	"contrast":   {},
+	"saturate":   {},
+}
+
+// layerBlends lists kinds whose renderer blends with the backdrop instead of
+// painting over it; their layer is composited with the same blend.
+var layerBlends = map[string]blendFunc{
+	"highlight": blendMultiply,
+}
+
+// transformMatrix returns t as a row-major affine matrix mapping op space to
+// canvas space, in the same continuous coordinates the rasterizer uses.
+func transformMatrix(t *core.Transform) f64.Aff3 {
+	sx, sy := 1.0, 1.0
+	if t.ScaleX != nil {
+		sx = *t.ScaleX
+	}
+	if t.ScaleY != nil {
+		sy = *t.ScaleY
+	}
+	kx := math.Tan(t.SkewX * math.Pi / 180)
+	ky := math.Tan(t.SkewY * math.Pi / 180)
+	sin, cos := math.Sincos(t.Rotate * math.Pi / 180)
+
+	// Linear part: rotation * skew * scale.
+	a := cos*sx - sin*ky*sx
+	b := cos*kx*sy - sin*sy
+	c := sin*sx + cos*ky*sx
+	d := sin*kx*sy + cos*sy
+	px, py := t.PivotX, t.PivotY
+	return f64.Aff3{
+		a, b, px + t.TranslateX - a*px - b*py,
+		c, d, py + t.TranslateY - c*px - d*py,
+	}
+}
+
+func affineDet(m f64.Aff3) float64 {
+	return m[0]*m[4] - m[1]*m[3]
+}
+
+func invertAffine(m f64.Aff3) f64.Aff3 {
+	det := affineDet(m)
+	a, b, c, d := m[4]/det, -m[1]/det, -m[3]/det, m[0]/det
+	return f64.Aff3{
+		a, b, -(a*m[2] + b*m[5]),
+		c, d, -(c*m[2] + d*m[5]),
+	}
+}
+
+func applyAffine(m f64.Aff3, p fpoint) fpoint {
+	return fpoint{m[0]*p.x + m[1]*p.y + m[2], m[3]*p.x + m[4]*p.y + m[5]}
+}
+
+func validateTransform(op core.AnnotationOp) error {
+	t := op.Transform
+	if t == nil {
+		return nil
+	}
+	if _, ok := pixelEffectKinds[op.Kind]; ok {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: fmt.Sprintf("transform is not supported for %s op: %s", op.Kind, op.ID)}
+	}
+	values := []float64{t.Rotate, t.SkewX, t.SkewY, t.TranslateX, t.TranslateY, t.PivotX, t.PivotY}
+	if t.ScaleX != nil {
+		values = append(values, *t.ScaleX)
+	}
+	if t.ScaleY != nil {
+		values = append(values, *t.ScaleY)
+	}
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "transform values must be finite for op: " + op.ID}
+		}
+	}
+	if math.Abs(t.SkewX) >= 90 || math.Abs(t.SkewY) >= 90 {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "transform skew must be between -90 and 90 degrees for op: " + op.ID}
+	}
+	if math.Abs(affineDet(transformMatrix(t))) < minTransformDet {
+		return &core.AppError{Code: core.ErrInvalidOpPayload, Message: "degenerate transform for op: " + op.ID}
+	}
+	return nil
+}
+
+// renderTransformed draws op into a transparent layer covering just its
+// footprint in op space, then warps the layer into place.
+func renderTransformed(dst draw.Image, op core.AnnotationOp, opts RenderOptions) error {
+	if err := validateTransform(op); err != nil {
+		return err
+	}
+	m := transformMatrix(op.Transform)
+	bounds := dst.Bounds()
+	// A small scale maps the canvas onto a huge part of op space; beyond one
+	// canvas of margin the op is too far away to matter, so stop there.
+	visible := transformedBounds(invertAffine(m), bounds).Intersect(bounds.Inset(-max(bounds.Dx(), bounds.Dy())))
+	if visible.Empty() {
+		return nil
+	}
+	ink := &inkRecorder{rect: visible}
+	if err := renderOp(ink, op, opts); err != nil {
+		return err
+	}
+	// One pixel of margin leaves room for bilinear sampling at the edges.
+	area := ink.ink.Inset(-1).Intersect(visible)
+	if area.Empty() {
+		return nil
+	}
+
+	layer := image.NewRGBA(area)
+	if err := renderOp(layer, op, opts); err != nil {
+		return err
+	}
+	blend, ok := layerBlends[op.Kind]
+	if !ok {
+		xdraw.BiLinear.Transform(dst, m, layer, area, xdraw.Over, nil)
+		return nil
+	}
+	warped := image.NewRGBA(transformedBounds(m, area).Intersect(bounds))
+	xdraw.BiLinear.Transform(warped, m, layer, area, xdraw.Src, nil)
+	blendLayer(dst, warped, warped.Rect, blend, 1)
+	return nil
+}
+
+// transformedBounds is the smallest pixel rectangle holding r mapped by m.
+func transformedBounds(m f64.Aff3, r image.Rectangle) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, c := range rectPoints(r.Min.X, r.Min.Y, r.Dx(), r.Dy()) {
+		p := applyAffine(m, c)
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	return image.Rect(int(math.Floor(minX))-1, int(math.Floor(minY))-1, int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1)
+}
+
+// inkRecorder is a transparent image that keeps no pixels and only records
+// the bounds of everything drawn onto it with non-zero alpha.
+type inkRecorder struct {
+	rect image.Rectangle
+	ink  image.Rectangle
+}
+
+func (r *inkRecorder) ColorModel() color.Model { return color.RGBAModel }
+
+func (r *inkRecorder) Bounds() image.Rectangle { return r.rect }
+
+func (r *inkRecorder) At(x, y int) color.Color { return color.RGBA{} }
+
+func (r *inkRecorder) Set(x, y int, c color.Color) {
+	if _, _, _, a := c.RGBA(); a == 0 || !image.Pt(x, y).In(r.rect) {
+		return
+	}
+	r.ink = r.ink.Union(image.Rect(x, y, x+1, y+1))
+}
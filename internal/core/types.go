@@ -19,25 +19,105 @@ type CaptureResult struct {
 	SessionID string        `json:"sessionId"`
 }
 
+// AnnotationOp is one drawing step. Transform, when set, is applied to
+// everything the op draws; Shadow and Outline are drawn beneath it. The
+// result is composited with Opacity (0-1, default 1) and Blend: "normal",
+// "multiply", "screen", "overlay" or "difference". An empty Blend keeps the
+// op's own mode, which is multiply for highlights and normal otherwise.
 type AnnotationOp struct {
-	ID      string          `json:"id"`
-	Kind    string          `json:"kind"`
-	Z       int             `json:"z"`
-	Payload json.RawMessage `json:"payload"`
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Z         int             `json:"z"`
+	Payload   json.RawMessage `json:"payload"`
+	Transform *Transform      `json:"transform,omitempty"`
+	Shadow    *Shadow         `json:"shadow,omitempty"`
+	Outline   *Outline        `json:"outline,omitempty"`
+	Opacity   *float64        `json:"opacity,omitempty"`
+	Blend     string          `json:"blend,omitempty"`
 }
 
+// Shadow is the op's silhouette offset by (X, Y), blurred with Blur as the
+// Gaussian radius and painted in Color (default rgba(0,0,0,0.5)).
+type Shadow struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Blur  int    `json:"blur,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// Outline is a halo Width pixels wide (default 2) around everything the op
+// draws. Color defaults to black or white, whichever contrasts with the op.
+type Outline struct {
+	Width int    `json:"width,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// Transform is an affine transform in canvas coordinates. Scale, then skew,
+// then rotation are applied around the pivot, followed by the translation.
+// Angles are in degrees and rotate clockwise on screen; the pivot defaults
+// to the canvas origin and an unset scale is 1.
+type Transform struct {
+	Rotate     float64  `json:"rotate,omitempty"`
+	ScaleX     *float64 `json:"scaleX,omitempty"`
+	ScaleY     *float64 `json:"scaleY,omitempty"`
+	SkewX      float64  `json:"skewX,omitempty"`
+	SkewY      float64  `json:"skewY,omitempty"`
+	TranslateX float64  `json:"translateX,omitempty"`
+	TranslateY float64  `json:"translateY,omitempty"`
+	PivotX     float64  `json:"pivotX,omitempty"`
+	PivotY     float64  `json:"pivotY,omitempty"`
+}
+
+type Rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type Insets struct {
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+	Left   int `json:"left"`
+}
+
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ExportRequest optionally reshapes the canvas. Crop (in base image pixels)
+// is applied first, then Pad adds a PadColor border, and Resize scales the
+// result; a zero Width or Height keeps the aspect ratio. Op coordinates always
+// refer to the uncropped base image. Assets holds embedded images (base64 in
+// JSON) that image ops reference by key instead of a file path. Scale is the
+// capture's DisplayInfo.Scale, used to label measurements in points. Palette
+// overrides the colors that ops with color "auto" choose from.
 type ExportRequest struct {
-	BaseImagePath string         `json:"baseImagePath"`
-	Ops           []AnnotationOp `json:"ops"`
-	Format        string         `json:"format"`
-	Quality       int            `json:"quality"`
-	OutputPath    string         `json:"outputPath"`
+	BaseImagePath string            `json:"baseImagePath"`
+	Ops           []AnnotationOp    `json:"ops"`
+	Format        string            `json:"format"`
+	Quality       int               `json:"quality"`
+	OutputPath    string            `json:"outputPath"`
+	Crop          *Rect             `json:"crop,omitempty"`
+	Pad           *Insets           `json:"pad,omitempty"`
+	PadColor      string            `json:"padColor,omitempty"`
+	Resize        *Size             `json:"resize,omitempty"`
+	Assets        map[string][]byte `json:"assets,omitempty"`
+	Scale         int               `json:"scale,omitempty"`
+	Palette       []string          `json:"palette,omitempty"`
 }
 
+// ExportResult describes the written file. Redacted lists the verified
+// redaction areas in base image coordinates.
 type ExportResult struct {
 	OutputPath string `json:"outputPath"`
 	Bytes      int64  `json:"bytes"`
 	Format     string `json:"format"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Redacted   []Rect `json:"redacted,omitempty"`
 }
 
 type AppState struct {
@@ -73,4 +153,6 @@ const (
 	ErrDecodeFailed        = "ERR_DECODE_FAILED"
 	ErrWriteFailed         = "ERR_WRITE_FAILED"
 	ErrReadFailed          = "ERR_READ_FAILED"
+	ErrRenderFailed        = "ERR_RENDER_FAILED"
+	ErrRedactionFailed     = "ERR_REDACTION_FAILED"
 )
@@ -24,6 +24,12 @@ func (s *Service) Export(_ context.Context, req core.ExportRequest) (core.Export
 	if err := annotate.ValidateOps(req.Ops); err != nil {
 		return core.ExportResult{}, err
 	}
+	if err := annotate.ValidateCanvas(req.Crop, req.Pad, req.PadColor, req.Resize); err != nil {
+		return core.ExportResult{}, err
+	}
+	if err := annotate.ValidatePalette(req.Palette); err != nil {
+		return core.ExportResult{}, err
+	}
 	f, err := os.Open(req.BaseImagePath)
 	if err != nil {
 		return core.ExportResult{}, &core.AppError{Code: core.ErrReadFailed, Message: err.Error()}
@@ -34,15 +40,19 @@ func (s *Service) Export(_ context.Context, req core.ExportRequest) (core.Export
 		return core.ExportResult{}, &core.AppError{Code: core.ErrDecodeFailed, Message: err.Error()}
 	}
 
-	rgba := image.NewRGBA(img.Bounds())
-	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
-		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-			rgba.Set(x, y, img.At(x, y))
-		}
+	canvas, err := annotate.NewCanvas(img, req.Crop, req.Pad, req.PadColor)
+	if err != nil {
+		return core.ExportResult{}, err
 	}
 
 	annotate.SortOps(req.Ops)
-	if err := annotate.ApplyOps(rgba, req.Ops); err != nil {
+	opts := annotate.RenderOptions{Assets: req.Assets, Scale: req.Scale, Palette: req.Palette}
+	redacted, err := annotate.ApplyRedactedOps(canvas, img, req.Ops, opts)
+	if err != nil {
+		return core.ExportResult{}, err
+	}
+	rgba, err := annotate.FinishCanvas(canvas, req.Resize)
+	if err != nil {
 		return core.ExportResult{}, err
 	}
 
@@ -84,7 +94,14 @@ func (s *Service) Export(_ context.Context, req core.ExportRequest) (core.Export
 		return core.ExportResult{}, &core.AppError{Code: core.ErrReadFailed, Message: err.Error()}
 	}
 	log.Printf("[export] saved format=%s bytes=%d output=%s", format, stat.Size(), req.OutputPath)
-	return core.ExportResult{OutputPath: req.OutputPath, Bytes: stat.Size(), Format: format}, nil
+	return core.ExportResult{
+		OutputPath: req.OutputPath,
+		Bytes:      stat.Size(),
+		Format:     format,
+		Width:      rgba.Rect.Dx(),
+		Height:     rgba.Rect.Dy(),
+		Redacted:   redacted,
+	}, nil
 }
 
 func defaultOutputPath(format string) string {
@@ -10,6 +10,7 @@ import (
 	"image/png"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/mohamoundaljadan/screenshot/internal/core"
@@ -27,7 +28,12 @@ func TestExportDeterministic(t *testing.T) {
 		Ops: []core.AnnotationOp{
 			{ID: "b", Kind: "line", Z: 2, Payload: json.RawMessage(`{"x1":10,"y1":10,"x2":60,"y2":60,"color":"#00ff00","strokeWidth":3}`)},
 			{ID: "a", Kind: "rect", Z: 1, Payload: json.RawMessage(`{"x":20,"y":15,"w":40,"h":30,"color":"#ff0000","strokeWidth":2}`)},
+			{ID: "d", Kind: "step", Z: 3, Payload: json.RawMessage(`{"x":30,"y":60,"color":"#0000ff"}`)},
+			{ID: "c", Kind: "step", Z: 3, Payload: json.RawMessage(`{"x":60,"y":60,"color":"#0000ff"}`)},
+			{ID: "e", Kind: "text", Z: 4, Payload: json.RawMessage(`{"x":10,"y":70,"text":"tilted","color":"#000000"}`), Transform: &core.Transform{Rotate: -15, PivotX: 10, PivotY: 70}},
+			{ID: "f", Kind: "rect", Z: 5, Payload: json.RawMessage(`{"x":5,"y":5,"w":70,"h":20,"color":"auto","strokeWidth":2}`)},
 		},
+		Palette: []string{"#ff00ff", "#00ffff"},
 	}
 
 	svc := NewService()
@@ -50,6 +56,203 @@ func TestExportDeterministic(t *testing.T) {
 	}
 }
 
+func TestExportCropPadKeepsOpCoordinates(t *testing.T) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "base.png")
+	writeBaseImage(t, base)
+
+	req := core.ExportRequest{
+		BaseImagePath: base,
+		Format:        "png",
+		OutputPath:    filepath.Join(tmp, "cropped.png"),
+		Crop:          &core.Rect{X: 20, Y: 10, W: 40, H: 30},
+		Pad:           &core.Insets{Top: 5, Right: 5, Bottom: 5, Left: 5},
+		PadColor:      "#ffffff",
+		Ops: []core.AnnotationOp{
+			{ID: "a", Kind: "rect", Z: 1, Payload: json.RawMessage(`{"x":30,"y":20,"w":4,"h":4,"color":"#000000","fill":true}`)},
+		},
+	}
+	result, err := NewService().Export(context.Background(), req)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if result.Width != 50 || result.Height != 40 {
+		t.Fatalf("expected 50x40 output, got %dx%d", result.Width, result.Height)
+	}
+	img := decodePNG(t, result.OutputPath)
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Fatalf("expected pad color at origin, got %v", got)
+	}
+	// Base pixel (30,20) lands at (30-20+5, 20-10+5).
+	if got := color.RGBAModel.Convert(img.At(15, 15)); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected annotation at mapped position, got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(5, 5)); got != (color.RGBA{R: 60, G: 30, B: 128, A: 255}) {
+		t.Fatalf("expected base pixel (20,10) after padding, got %v", got)
+	}
+
+	req.OutputPath = filepath.Join(tmp, "resized.png")
+	req.Resize = &core.Size{Width: 100}
+	result, err = NewService().Export(context.Background(), req)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if result.Width != 100 || result.Height != 80 {
+		t.Fatalf("expected 100x80 output, got %dx%d", result.Width, result.Height)
+	}
+}
+
+func TestExportRecordsRedactedAreas(t *testing.T) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "base.png")
+	writeBaseImage(t, base)
+
+	req := core.ExportRequest{
+		BaseImagePath: base,
+		Format:        "png",
+		OutputPath:    filepath.Join(tmp, "redacted.png"),
+		Crop:          &core.Rect{X: 20, Y: 10, W: 40, H: 30},
+		Pad:           &core.Insets{Top: 5, Right: 5, Bottom: 5, Left: 5},
+		Ops: []core.AnnotationOp{
+			{ID: "r", Kind: "redact", Payload: json.RawMessage(`{"x":50,"y":35,"w":30,"h":30}`)},
+			{ID: "s", Kind: "redact", Z: 1, Payload: json.RawMessage(`{"x":200,"y":200,"w":5,"h":5}`)},
+		},
+	}
+	result, err := NewService().Export(context.Background(), req)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if len(result.Redacted) != 1 || result.Redacted[0] != (core.Rect{X: 50, Y: 35, W: 15, H: 10}) {
+		t.Fatalf("expected one redaction clipped to the padded canvas, got %v", result.Redacted)
+	}
+	img := decodePNG(t, result.OutputPath)
+	// Base pixel (55,40) lands at (55-20+5, 40-10+5).
+	if got := color.RGBAModel.Convert(img.At(40, 35)); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected redacted pixel, got %v", got)
+	}
+}
+
+func TestExportRedactionHidesOpsDrawnBeforeIt(t *testing.T) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "base.png")
+	writeBaseImage(t, base)
+
+	req := core.ExportRequest{
+		BaseImagePath: base,
+		Format:        "png",
+		OutputPath:    filepath.Join(tmp, "redacted.png"),
+		Ops: []core.AnnotationOp{
+			{ID: "r", Kind: "redact", Z: 2, Payload: json.RawMessage(`{"x":10,"y":10,"w":20,"h":20,"mode":"solid","color":"#000000"}`)},
+			{ID: "a", Kind: "rect", Z: 1, Payload: json.RawMessage(`{"x":15,"y":15,"w":10,"h":10,"color":"#00ff00","fill":true}`)},
+			{ID: "b", Kind: "rect", Z: 3, Payload: json.RawMessage(`{"x":12,"y":12,"w":2,"h":2,"color":"#ff0000","fill":true}`)},
+		},
+	}
+	result, err := NewService().Export(context.Background(), req)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	img := decodePNG(t, result.OutputPath)
+	if got := color.RGBAModel.Convert(img.At(20, 20)); got != (color.RGBA{A: 255}) {
+		t.Fatalf("expected the rect below the redaction to be hidden, got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(12, 12)); got != (color.RGBA{R: 255, A: 255}) {
+		t.Fatalf("expected the rect above the redaction to show, got %v", got)
+	}
+}
+
+func TestExportRejectsOversizedDerivedResize(t *testing.T) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "base.png")
+	writeBaseImage(t, base)
+
+	// An 80x1 strip resized to the maximum height would be 1310720 wide.
+	req := core.ExportRequest{
+		BaseImagePath: base,
+		Format:        "png",
+		OutputPath:    filepath.Join(tmp, "strip.png"),
+		Crop:          &core.Rect{X: 0, Y: 0, W: 80, H: 1},
+		Resize:        &core.Size{Height: 16384},
+	}
+	if _, err := NewService().Export(context.Background(), req); err == nil {
+		t.Fatal("expected error for oversized derived resize width")
+	}
+}
+
+func TestExportImageStampDeterministic(t *testing.T) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "base.png")
+	writeBaseImage(t, base)
+
+	stamp := image.NewRGBA(image.Rect(0, 0, 10, 6))
+	for i := 0; i < len(stamp.Pix); i += 4 {
+		stamp.Pix[i], stamp.Pix[i+3] = 255, 255
+	}
+	stampPath := filepath.Join(tmp, "stamp.png")
+	f, err := os.Create(stampPath)
+	if err != nil {
+		t.Fatalf("create stamp: %v", err)
+	}
+	if err := png.Encode(f, stamp); err != nil {
+		t.Fatalf("encode stamp: %v", err)
+	}
+	_ = f.Close()
+	data, err := os.ReadFile(stampPath)
+	if err != nil {
+		t.Fatalf("read stamp: %v", err)
+	}
+
+	req := core.ExportRequest{
+		BaseImagePath: base,
+		Format:        "png",
+		OutputPath:    filepath.Join(tmp, "stamped.png"),
+		Assets:        map[string][]byte{"approved": data},
+		Ops: []core.AnnotationOp{
+			{ID: "a", Kind: "image", Z: 1, Payload: json.RawMessage(`{"x":5,"y":5,"path":` + strconv.Quote(stampPath) + `}`)},
+			{ID: "b", Kind: "image", Z: 2, Payload: json.RawMessage(`{"x":40,"y":40,"ref":"approved","scale":2,"rotation":30,"opacity":0.5}`)},
+		},
+	}
+	svc := NewService()
+	result, err := svc.Export(context.Background(), req)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	h1 := hashFile(t, result.OutputPath)
+	img := decodePNG(t, result.OutputPath)
+	if got := color.RGBAModel.Convert(img.At(10, 8)); got != (color.RGBA{R: 255, A: 255}) {
+		t.Fatalf("expected stamp pixel, got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(50, 46)).(color.RGBA); got.R <= 150 || got.R == 255 {
+		t.Fatalf("expected half-transparent rotated stamp, got %v", got)
+	}
+
+	result, err = svc.Export(context.Background(), req)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if h2 := hashFile(t, result.OutputPath); h1 != h2 {
+		t.Fatalf("expected deterministic output hash, got %s vs %s", h1, h2)
+	}
+
+	req.Ops = []core.AnnotationOp{{ID: "c", Kind: "image", Payload: json.RawMessage(`{"x":0,"y":0,"ref":"missing"}`)}}
+	if _, err := svc.Export(context.Background(), req); err == nil {
+		t.Fatal("expected error for unknown asset")
+	}
+}
+
+func decodePNG(t *testing.T, p string) image.Image {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	return img
+}
+
 func writeBaseImage(t *testing.T, p string) {
 	t.Helper()
 	img := image.NewRGBA(image.Rect(0, 0, 80, 80))